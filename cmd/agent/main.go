@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/yurchenkosv/metric-service/internal/agent"
+	"github.com/yurchenkosv/metric-service/internal/collector"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
 	"github.com/yurchenkosv/metric-service/internal/functions"
+	pb "github.com/yurchenkosv/metric-service/internal/proto"
 	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
 )
 
 var (
-	cfg = types.AgentConfig{}
+	cfg      = types.AgentConfig{}
+	grpcConn *grpc.ClientConn
 )
 
 func init() {
@@ -25,41 +32,105 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err = functions.ParseLabels(cfg.Labels); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.CryptoKey != "" {
+		if _, err = encryption.LoadPublicKey(cfg.CryptoKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err = functions.ServerURL(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err = functions.CheckGRPCAgentConfig(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Scheme == "https" {
+		if _, err = functions.NewAgentTLSConfig(&cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var push functions.PushFunc
+	switch cfg.Transport {
+	case "", "http":
+		client, err := functions.NewPushClient(&cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		push = func(ctx context.Context, m types.Metrics) error {
+			return functions.PushMemMetrics(ctx, client, m)
+		}
+	case "grpc":
+		grpcConn, err = functions.NewGRPCConn(&cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcClient := pb.NewMetricsClient(grpcConn)
+		push = func(ctx context.Context, m types.Metrics) error {
+			return functions.PushMemMetricsGRPC(ctx, grpcClient, m, &cfg)
+		}
+	default:
+		log.Fatalf("unsupported transport %q", cfg.Transport)
+	}
+	if cfg.OutboxDir != "" {
+		outbox, err := functions.NewOutbox(&cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		push = outbox.Push(push)
+	}
+	if cfg.RateLimit < 1 {
+		log.Fatalf("rate limit must be positive, got %d", cfg.RateLimit)
+	}
+	polls := &agent.PollCounter{}
+	sender := functions.NewSender(polls.Push(push), cfg.RateLimit, cfg.QueueSize, cfg.PollInterval)
+	registry, err := collector.NewDefaultRegistry(&cfg, sender)
+	if err != nil {
+		log.Fatal(err)
+	}
 	log.WithFields(
 		log.Fields{
 			"poolInterval": cfg.PollInterval,
 			"address":      cfg.Address,
 		}).Info("Starting metric agent")
 
-	mainLoop := time.NewTicker(cfg.PollInterval)
-	pushLoop := time.NewTicker(cfg.ReportInterval)
-	mainLoopStop := make(chan bool)
-	memMetrics := make(chan types.Metrics)
 	osSignal := make(chan os.Signal, 3)
 	signal.Notify(osSignal, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
+	collectCtx, stopCollectors := context.WithCancel(context.Background())
+	collectorsStopped := make(chan struct{})
 	go func() {
-		var pollCount int
-		for {
-			select {
-			case <-mainLoopStop:
-				return
-			case <-mainLoop.C:
-				pollCount = 1
-				functions.CollectMemMetrics(pollCount, &cfg)
-			case <-pushLoop.C:
-				memMetrics <- functions.CollectMemMetrics(pollCount, &cfg)
-			}
-		}
+		registry.Run(collectCtx)
+		close(collectorsStopped)
 	}()
 
+	pushCtx, cancelPushes := context.WithCancel(context.Background())
+	sender.Start(pushCtx)
+	loop := agent.NewLoop(&cfg, registry, polls, sender.Send)
+	loopCtx, stopLoop := context.WithCancel(context.Background())
+	loopStopped := make(chan struct{})
 	go func() {
-		for {
-			functions.PushMemMetrics(<-memMetrics, &cfg)
-		}
+		loop.Run(loopCtx)
+		close(loopStopped)
 	}()
 
-	<-osSignal
-	functions.Cleanup(mainLoop, pushLoop, mainLoopStop)
-	os.Exit(0)
+	sig := <-osSignal
+	log.WithField("signal", sig.String()).Info("Sending last report before exit")
+	deadline := time.AfterFunc(cfg.ShutdownTimeout, cancelPushes)
+	stopLoop()
+	<-loopStopped
+	stopCollectors()
+	<-collectorsStopped
+	registry.CollectAll(context.Background())
+	if err := sender.SendWait(pushCtx, loop.Report()); err != nil {
+		log.Error(err)
+	}
+
+	sender.Close()
+	deadline.Stop()
+	cancelPushes()
+	if grpcConn != nil {
+		grpcConn.Close()
+	}
 }
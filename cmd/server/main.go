@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
 	"github.com/yurchenkosv/metric-service/internal/functions"
+	"github.com/yurchenkosv/metric-service/internal/grpcserver"
 	migration "github.com/yurchenkosv/metric-service/internal/migrate"
 	"github.com/yurchenkosv/metric-service/internal/storage"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,12 +18,14 @@ import (
 
 	"github.com/yurchenkosv/metric-service/internal/routers"
 	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
 )
 
 var (
 	cfg        = types.ServerConfig{}
 	storeLoop  *time.Ticker
 	mapStorage storage.Repository
+	dbStorage  interface{ Close() }
 )
 
 func init() {
@@ -39,29 +46,58 @@ func main() {
 			"address": cfg.Address,
 		}).Info("Starting metric agent")
 
-	if cfg.DBDsn != "" {
+	if _, err = functions.ParseLabels(cfg.PrometheusLabels); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.CryptoKey != "" {
+		if _, err = encryption.LoadPrivateKey(cfg.CryptoKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cfg.TrustedSubnet != "" {
+		if _, _, err = net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = functions.CheckGRPCServerConfig(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig, err := functions.NewServerTLSConfig(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case cfg.Storage != "" && cfg.DBDsn != "":
+		log.Fatal("-storage and -d can not be used together")
+	case cfg.Storage != "":
+		if _, err = storage.SQLitePath(cfg.Storage); err != nil {
+			log.Fatal(err)
+		}
+		migration.Migrate(cfg.Storage)
+		sqliteStorage, err := storage.NewSQLiteStorage(context.Background(), &cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mapStorage, dbStorage = sqliteStorage, sqliteStorage
+	case cfg.DBDsn != "":
 		migration.Migrate(cfg.DBDsn)
-		mapStorage = storage.NewPostgresStorage(&cfg)
-	} else {
-		mapStorage = storage.NewMapStorage()
+		pgStorage, err := storage.NewPostgresStorage(context.Background(), &cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mapStorage, dbStorage = pgStorage, pgStorage
+	default:
+		mapStorage = storage.NewMapStorageWithHistory(cfg.HistorySize)
 	}
 
 	if cfg.Restore {
-		mapStorage = functions.ReadMetricsFromDisk(&cfg, &mapStorage)
+		mapStorage = functions.ReadMetricsFromDisk(context.Background(), &cfg, &mapStorage)
 	}
 
 	signal.Notify(osSignal, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
 
-	go func() {
-		<-osSignal
-		if cfg.StoreInterval != 0 && cfg.DBDsn == "" {
-			storeLoopStop <- true
-		}
-		functions.FlushMetricsToDisk(&cfg, mapStorage)
-		os.Exit(0)
-	}()
-
-	if cfg.StoreInterval != 0 && cfg.DBDsn == "" {
+	if cfg.StoreInterval != 0 && dbStorage == nil {
 		storeLoop = time.NewTicker(cfg.StoreInterval)
 		go func() {
 			for {
@@ -69,14 +105,78 @@ func main() {
 				case <-storeLoopStop:
 					return
 				case <-storeLoop.C:
-					functions.FlushMetricsToDisk(&cfg, mapStorage)
+					functions.FlushMetricsToDisk(context.Background(), &cfg, mapStorage)
 				}
 			}
 
 		}()
 	}
 
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcServer = grpcserver.NewServer(&cfg, mapStorage, tlsConfig)
+		log.WithField("address", cfg.GRPCAddress).Info("Starting gRPC server")
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	router := routers.NewRouter(&cfg, &mapStorage)
-	server := &http.Server{Addr: cfg.Address, Handler: router}
-	log.Fatal(server.ListenAndServe())
+	server := &http.Server{Addr: cfg.Address, Handler: router, TLSConfig: tlsConfig}
+
+	serversStopped := make(chan struct{})
+	go func() {
+		sig := <-osSignal
+		log.WithField("signal", sig.String()).Info("Shutting down metric server")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error(err)
+		}
+		if grpcServer != nil {
+			stopGRPCServer(ctx, grpcServer)
+		}
+		close(serversStopped)
+	}()
+
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-serversStopped
+
+	if cfg.StoreInterval != 0 && dbStorage == nil {
+		storeLoop.Stop()
+		storeLoopStop <- true
+	}
+	functions.FlushMetricsToDisk(context.Background(), &cfg, mapStorage)
+	if dbStorage != nil {
+		dbStorage.Close()
+	}
+	log.Info("Metric server stopped")
+}
+
+// stopGRPCServer waits for in-flight calls until ctx is done, then closes
+// remaining connections.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
 }
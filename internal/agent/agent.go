@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/collector"
+	"github.com/yurchenkosv/metric-service/internal/functions"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// Ticker is the part of time.Ticker the agent loop uses.
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// Clock creates tickers; tests replace it with a fake one.
+type Clock interface {
+	NewTicker(d time.Duration) Ticker
+}
+
+type realClock struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// PollCounter counts polls the server has not received yet.
+type PollCounter struct {
+	pending int64
+}
+
+func (p *PollCounter) Inc() {
+	atomic.AddInt64(&p.pending, 1)
+}
+
+// Take returns the pending polls for a report and resets them. Push gives
+// them back if the report is not delivered.
+func (p *PollCounter) Take() int64 {
+	return atomic.SwapInt64(&p.pending, 0)
+}
+
+// Push wraps push so that PollCount of a failed report is added to the next
+// one.
+func (p *PollCounter) Push(push functions.PushFunc) functions.PushFunc {
+	return func(ctx context.Context, m types.Metrics) error {
+		err := push(ctx, m)
+		if err != nil {
+			p.restore(m)
+		}
+		return err
+	}
+}
+
+// restore adds PollCount of an undelivered report back to pending polls.
+func (p *PollCounter) restore(m types.Metrics) {
+	for _, metric := range m.Metric {
+		if metric.ID == "PollCount" && metric.MType == "counter" && metric.Delta != nil {
+			atomic.AddInt64(&p.pending, *metric.Delta)
+		}
+	}
+}
+
+// Loop counts polls every PollInterval and hands a report with everything
+// collected since the previous one to send every ReportInterval. send returns
+// false if it dropped the report; its polls are then reported next time.
+type Loop struct {
+	cfg      *types.AgentConfig
+	clock    Clock
+	registry *collector.Registry
+	polls    *PollCounter
+	send     func(types.Metrics) bool
+}
+
+func NewLoop(cfg *types.AgentConfig, registry *collector.Registry, polls *PollCounter, send func(types.Metrics) bool) *Loop {
+	return &Loop{
+		cfg:      cfg,
+		clock:    realClock{},
+		registry: registry,
+		polls:    polls,
+		send:     send,
+	}
+}
+
+// Run polls and reports until ctx is done.
+func (l *Loop) Run(ctx context.Context) {
+	pollTicker := l.clock.NewTicker(l.cfg.PollInterval)
+	defer pollTicker.Stop()
+	reportTicker := l.clock.NewTicker(l.cfg.ReportInterval)
+	defer reportTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pollTicker.Chan():
+			l.polls.Inc()
+		case <-reportTicker.Chan():
+			report := l.Report()
+			if !l.send(report) {
+				l.polls.restore(report)
+			}
+		}
+	}
+}
+
+// Report drains collected metrics and pending polls into a report.
+func (l *Loop) Report() types.Metrics {
+	return functions.PrepareReport(l.registry.Drain(), int(l.polls.Take()), l.cfg)
+}
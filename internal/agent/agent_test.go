@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/collector"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+type fakeTicker struct {
+	c chan time.Time
+}
+
+func (t fakeTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {}
+
+// fakeClock hands out tickers in creation order: poll, then report.
+type fakeClock struct {
+	tickers chan fakeTicker
+}
+
+func (c fakeClock) NewTicker(d time.Duration) Ticker {
+	t := fakeTicker{c: make(chan time.Time)}
+	c.tickers <- t
+	return t
+}
+
+func pollCount(t *testing.T, report types.Metrics) int64 {
+	for _, metric := range report.Metric {
+		if metric.ID == "PollCount" {
+			return *metric.Delta
+		}
+	}
+	t.Fatal("PollCount is missing in report")
+	return 0
+}
+
+func TestLoopPollCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		polls   []int
+		failed  []bool
+		dropped []bool
+		want    []int64
+	}{
+		{
+			name:   "counts polls between reports",
+			polls:  []int{3, 2},
+			failed: []bool{false, false},
+			want:   []int64{3, 2},
+		},
+		{
+			name:   "keeps polls of failed reports",
+			polls:  []int{3, 2, 1},
+			failed: []bool{true, false, false},
+			want:   []int64{3, 5, 1},
+		},
+		{
+			name:    "keeps polls of reports dropped by a full queue",
+			polls:   []int{3, 2, 1},
+			dropped: []bool{true, false, false},
+			want:    []int64{5, 1},
+		},
+		{
+			name:  "reports no polls",
+			polls: []int{0},
+			want:  []int64{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := fakeClock{tickers: make(chan fakeTicker, 2)}
+			polls := &PollCounter{}
+			var reports []types.Metrics
+			push := polls.Push(func(ctx context.Context, m types.Metrics) error {
+				failed := len(tt.failed) > len(reports) && tt.failed[len(reports)]
+				reports = append(reports, m)
+				if failed {
+					return errors.New("server unavailable")
+				}
+				return nil
+			})
+			sent := 0
+			send := func(m types.Metrics) bool {
+				sent++
+				if len(tt.dropped) >= sent && tt.dropped[sent-1] {
+					return false
+				}
+				push(context.Background(), m)
+				return true
+			}
+
+			cfg := &types.AgentConfig{PollInterval: time.Second, ReportInterval: 10 * time.Second}
+			loop := NewLoop(cfg, collector.NewRegistry(), polls, send)
+			loop.clock = clock
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				loop.Run(ctx)
+				close(stopped)
+			}()
+			pollTicker, reportTicker := <-clock.tickers, <-clock.tickers
+
+			for _, n := range tt.polls {
+				for i := 0; i < n; i++ {
+					pollTicker.c <- time.Now()
+				}
+				reportTicker.c <- time.Now()
+			}
+			cancel()
+			<-stopped
+
+			require.Len(t, reports, len(tt.want))
+			for i, want := range tt.want {
+				assert.Equal(t, want, pollCount(t, reports[i]))
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// Collector gathers one group of agent metrics every Interval. Counters and
+// histograms it returns are deltas since its previous Collect; gauges and
+// summaries are snapshots.
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) ([]types.Metric, error)
+}
+
+// Registry runs enabled collectors and keeps what they collected until the
+// next report drains it.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []Collector
+	disabled   map[string]bool
+	pending    map[string]types.Metric
+	order      []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		disabled: make(map[string]bool),
+		pending:  make(map[string]types.Metric),
+	}
+}
+
+// Register adds a collector; names must be unique.
+func (r *Registry) Register(c Collector) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, registered := range r.collectors {
+		if registered.Name() == c.Name() {
+			return fmt.Errorf("collector %q is already registered", c.Name())
+		}
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+// Configure enables only the collectors named in enabled, or all of them if
+// it is empty, and then disables the ones named in disabled.
+func (r *Registry) Configure(enabled []string, disabled []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	known := make(map[string]bool, len(r.collectors))
+	for _, c := range r.collectors {
+		known[c.Name()] = true
+	}
+	for _, name := range append(append([]string{}, enabled...), disabled...) {
+		if !known[name] {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	r.disabled = make(map[string]bool)
+	if len(enabled) > 0 {
+		for name := range known {
+			r.disabled[name] = true
+		}
+		for _, name := range enabled {
+			delete(r.disabled, name)
+		}
+	}
+	for _, name := range disabled {
+		r.disabled[name] = true
+	}
+	return nil
+}
+
+// Enabled returns enabled collectors in registration order.
+func (r *Registry) Enabled() []Collector {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var enabled []Collector
+	for _, c := range r.collectors {
+		if !r.disabled[c.Name()] {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// Collect runs one collection of c and merges the result into pending
+// metrics. Metrics collected before an error are kept.
+func (r *Registry) Collect(ctx context.Context, c Collector) {
+	metrics, err := c.Collect(ctx)
+	if err != nil {
+		log.Printf("collector %s: %v", c.Name(), err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, metric := range metrics {
+		key := metric.MType + ":" + types.SeriesKey(metric.ID, metric.Labels)
+		stored, ok := r.pending[key]
+		if !ok {
+			r.order = append(r.order, key)
+			r.pending[key] = metric
+			continue
+		}
+		r.pending[key] = types.AccumulateMetric(stored, metric)
+	}
+}
+
+// CollectAll runs every enabled collector once.
+func (r *Registry) CollectAll(ctx context.Context) {
+	for _, c := range r.Enabled() {
+		r.Collect(ctx, c)
+	}
+}
+
+// Run collects from every enabled collector on its own interval until ctx is
+// done.
+func (r *Registry) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range r.Enabled() {
+		wg.Add(1)
+		go func(c Collector) {
+			defer wg.Done()
+			ticker := time.NewTicker(c.Interval())
+			defer ticker.Stop()
+			r.Collect(ctx, c)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					r.Collect(ctx, c)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Drain returns pending metrics in the order they were first collected.
+// Counters and histograms are reset, so they are reported once; gauges and
+// summaries are reported again until replaced.
+func (r *Registry) Drain() []types.Metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	metrics := make([]types.Metric, 0, len(r.order))
+	order := r.order[:0]
+	for _, key := range r.order {
+		metric := r.pending[key]
+		metrics = append(metrics, metric)
+		if metric.MType == "counter" || metric.MType == "histogram" {
+			delete(r.pending, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	r.order = order
+	return metrics
+}
+
+// ParseNames reads a comma-separated list of collector names.
+func ParseNames(names string) []string {
+	var result []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// NewDefaultRegistry registers the built-in runtime, random and system
+// collectors followed by extra ones, and applies the agent collector
+// settings to all of them.
+func NewDefaultRegistry(cfg *types.AgentConfig, extra ...Collector) (*Registry, error) {
+	registry := NewRegistry()
+	collectors := []Collector{
+		NewRuntimeCollector(cfg.PollInterval),
+		NewRandomCollector(cfg.PollInterval),
+		NewSystemCollector(cfg.SystemInterval),
+	}
+	for _, c := range append(collectors, extra...) {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	err := registry.Configure(ParseNames(cfg.Collectors), ParseNames(cfg.DisabledCollectors))
+	if err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
@@ -0,0 +1,166 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// stubCollector returns a prepared batch on every call.
+type stubCollector struct {
+	name    string
+	mutex   sync.Mutex
+	calls   int
+	metrics func(call int) []types.Metric
+	err     error
+}
+
+func (c *stubCollector) Name() string {
+	return c.name
+}
+
+func (c *stubCollector) Interval() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func (c *stubCollector) Collect(ctx context.Context) ([]types.Metric, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls++
+	return c.metrics(c.calls), c.err
+}
+
+func names(collectors []Collector) []string {
+	var result []string
+	for _, c := range collectors {
+		result = append(result, c.Name())
+	}
+	return result
+}
+
+func TestRegistryConfigure(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  string
+		disabled string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "should enable all by default", want: []string{"runtime", "random", "system"}},
+		{name: "should enable only listed", enabled: "system, random", want: []string{"random", "system"}},
+		{name: "should disable listed", disabled: "random", want: []string{"runtime", "system"}},
+		{name: "should reject unknown collector", enabled: "gpu", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewDefaultRegistry(&types.AgentConfig{Collectors: tt.enabled, DisabledCollectors: tt.disabled})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(registry.Enabled()))
+		})
+	}
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(NewRandomCollector(time.Second)))
+	assert.Error(t, registry.Register(NewRandomCollector(time.Second)))
+}
+
+func TestRegistryDrain(t *testing.T) {
+	sum := 1.0
+	count := uint64(1)
+	stub := &stubCollector{
+		name: "stub",
+		metrics: func(call int) []types.Metric {
+			delta := int64(call)
+			value := float64(call)
+			return []types.Metric{
+				{ID: "Requests", MType: "counter", Delta: &delta},
+				{ID: "Temperature", MType: "gauge", Value: &value},
+				{ID: "Latency", MType: "histogram", Sum: &sum, Count: &count, Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+			}
+		},
+		err: errors.New("partially collected"),
+	}
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(stub))
+
+	registry.CollectAll(context.Background())
+	registry.CollectAll(context.Background())
+	metrics := registry.Drain()
+	require.Len(t, metrics, 3)
+	assert.Equal(t, int64(3), *metrics[0].Delta, "counters are summed")
+	assert.Equal(t, 2.0, *metrics[1].Value, "gauges are replaced")
+	assert.Equal(t, uint64(2), *metrics[2].Count)
+	assert.Equal(t, 2.0, *metrics[2].Sum)
+	assert.Equal(t, uint64(2), metrics[2].Buckets[0].Count)
+
+	metrics = registry.Drain()
+	require.Len(t, metrics, 1, "deltas are reported once")
+	assert.Equal(t, "Temperature", metrics[0].ID)
+}
+
+func TestRegistryRun(t *testing.T) {
+	stub := &stubCollector{
+		name: "stub",
+		metrics: func(call int) []types.Metric {
+			delta := int64(1)
+			return []types.Metric{{ID: "Ticks", MType: "counter", Delta: &delta}}
+		},
+	}
+	disabled := &stubCollector{name: "disabled", metrics: func(int) []types.Metric { return nil }}
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(stub))
+	require.NoError(t, registry.Register(disabled))
+	require.NoError(t, registry.Configure(nil, []string{"disabled"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		stub.mutex.Lock()
+		defer stub.mutex.Unlock()
+		return stub.calls >= 3
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	metrics := registry.Drain()
+	require.Len(t, metrics, 1)
+	stub.mutex.Lock()
+	assert.Equal(t, int64(stub.calls), *metrics[0].Delta)
+	stub.mutex.Unlock()
+	assert.Zero(t, disabled.calls)
+}
+
+func TestSystemCollector(t *testing.T) {
+	metrics, err := NewSystemCollector(time.Second).Collect(context.Background())
+	if err != nil {
+		t.Log(err)
+	}
+
+	byID := make(map[string]types.Metric)
+	for _, metric := range metrics {
+		assert.Equal(t, "gauge", metric.MType)
+		byID[metric.ID] = metric
+	}
+	require.Contains(t, byID, "TotalMemory")
+	require.Contains(t, byID, "FreeMemory")
+	require.Contains(t, byID, "CPUutilization1")
+	assert.Greater(t, *byID["TotalMemory"].Value, 0.0)
+	assert.LessOrEqual(t, *byID["FreeMemory"].Value, *byID["TotalMemory"].Value)
+	if disk, ok := byID["DiskTotal"]; ok {
+		assert.NotEmpty(t, disk.Labels["mountpoint"])
+	}
+}
@@ -0,0 +1,30 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+type randomCollector struct {
+	interval time.Duration
+}
+
+// NewRandomCollector reports RandomValue gauge.
+func NewRandomCollector(interval time.Duration) Collector {
+	return &randomCollector{interval: interval}
+}
+
+func (c *randomCollector) Name() string {
+	return "random"
+}
+
+func (c *randomCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *randomCollector) Collect(ctx context.Context) ([]types.Metric, error) {
+	return []types.Metric{gauge("RandomValue", rand.Float64())}, nil
+}
@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// gcPauseBuckets are upper bounds of GC pause histogram buckets in nanoseconds.
+var gcPauseBuckets = []float64{1e4, 5e4, 1e5, 5e5, 1e6, 5e6, 1e7, 5e7, 1e8}
+
+var gcPauseQuantiles = []float64{0.5, 0.9, 0.99}
+
+func gauge(name string, value float64) types.Metric {
+	return types.Metric{ID: name, MType: "gauge", Value: &value}
+}
+
+type runtimeCollector struct {
+	interval  time.Duration
+	mutex     sync.Mutex
+	lastNumGC uint32
+}
+
+// NewRuntimeCollector reports runtime.MemStats of the agent process.
+func NewRuntimeCollector(interval time.Duration) Collector {
+	return &runtimeCollector{interval: interval}
+}
+
+func (c *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *runtimeCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *runtimeCollector) Collect(ctx context.Context) ([]types.Metric, error) {
+	var rtm runtime.MemStats
+	runtime.ReadMemStats(&rtm)
+	metrics := []types.Metric{
+		gauge("Alloc", float64(rtm.Alloc)),
+		gauge("BuckHashSys", float64(rtm.BuckHashSys)),
+		gauge("Frees", float64(rtm.Frees)),
+		gauge("GCCPUFraction", rtm.GCCPUFraction),
+		gauge("GCSys", float64(rtm.GCSys)),
+		gauge("HeapAlloc", float64(rtm.HeapAlloc)),
+		gauge("HeapIdle", float64(rtm.HeapIdle)),
+		gauge("HeapInuse", float64(rtm.HeapInuse)),
+		gauge("HeapObjects", float64(rtm.HeapObjects)),
+		gauge("HeapReleased", float64(rtm.HeapReleased)),
+		gauge("HeapSys", float64(rtm.HeapSys)),
+		gauge("LastGC", float64(rtm.LastGC)),
+		gauge("Lookups", float64(rtm.Lookups)),
+		gauge("MCacheInuse", float64(rtm.MCacheInuse)),
+		gauge("MCacheSys", float64(rtm.MCacheSys)),
+		gauge("MSpanInuse", float64(rtm.MSpanInuse)),
+		gauge("MSpanSys", float64(rtm.MSpanSys)),
+		gauge("Mallocs", float64(rtm.Mallocs)),
+		gauge("NextGC", float64(rtm.NextGC)),
+		gauge("NumForcedGC", float64(rtm.NumForcedGC)),
+		gauge("NumGC", float64(rtm.NumGC)),
+		gauge("OtherSys", float64(rtm.OtherSys)),
+		gauge("PauseTotalNs", float64(rtm.PauseTotalNs)),
+		gauge("StackInuse", float64(rtm.StackInuse)),
+		gauge("StackSys", float64(rtm.StackSys)),
+		gauge("Sys", float64(rtm.Sys)),
+		gauge("TotalAlloc", float64(rtm.TotalAlloc)),
+	}
+	return append(metrics, c.gcPauseMetrics(&rtm)...), nil
+}
+
+// gcPauseMetrics reports GC pauses as a histogram of pauses happened since
+// the previous call and a summary over the most recent pauses.
+func (c *runtimeCollector) gcPauseMetrics(rtm *runtime.MemStats) []types.Metric {
+	c.mutex.Lock()
+	newPauses := rtm.NumGC - c.lastNumGC
+	if rtm.NumGC < c.lastNumGC {
+		newPauses = rtm.NumGC
+	}
+	c.lastNumGC = rtm.NumGC
+	c.mutex.Unlock()
+
+	return []types.Metric{
+		pauseHistogram(recentPauses(rtm, newPauses)),
+		pauseSummary(recentPauses(rtm, rtm.NumGC), rtm.PauseTotalNs, rtm.NumGC),
+	}
+}
+
+// recentPauses returns up to n latest pauses from the MemStats circular buffer.
+func recentPauses(rtm *runtime.MemStats, n uint32) []float64 {
+	if n > uint32(len(rtm.PauseNs)) {
+		n = uint32(len(rtm.PauseNs))
+	}
+	pauses := make([]float64, 0, n)
+	for i := uint32(0); i < n; i++ {
+		idx := (rtm.NumGC - 1 - i + uint32(len(rtm.PauseNs))) % uint32(len(rtm.PauseNs))
+		pauses = append(pauses, float64(rtm.PauseNs[idx]))
+	}
+	return pauses
+}
+
+func pauseHistogram(pauses []float64) types.Metric {
+	var sum float64
+	count := uint64(len(pauses))
+	buckets := make([]types.Bucket, len(gcPauseBuckets))
+	for i, bound := range gcPauseBuckets {
+		buckets[i].UpperBound = bound
+	}
+	for _, pause := range pauses {
+		sum += pause
+		for i := range buckets {
+			if pause <= buckets[i].UpperBound {
+				buckets[i].Count++
+			}
+		}
+	}
+	return types.Metric{
+		ID:      "GCPauseNs",
+		MType:   "histogram",
+		Sum:     &sum,
+		Count:   &count,
+		Buckets: buckets,
+	}
+}
+
+func pauseSummary(pauses []float64, total uint64, numGC uint32) types.Metric {
+	sum := float64(total)
+	count := uint64(numGC)
+	metric := types.Metric{
+		ID:    "GCPauseNsQuantiles",
+		MType: "summary",
+		Sum:   &sum,
+		Count: &count,
+	}
+	if len(pauses) == 0 {
+		return metric
+	}
+	sort.Float64s(pauses)
+	for _, q := range gcPauseQuantiles {
+		idx := int(math.Ceil(q*float64(len(pauses)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		metric.Quantiles = append(metric.Quantiles, types.Quantile{Quantile: q, Value: pauses[idx]})
+	}
+	return metric
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/functions"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	c := NewRuntimeCollector(0)
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, metrics, 29)
+	for _, metric := range metrics {
+		assert.NoError(t, functions.ValidateMetric(metric))
+	}
+
+	runtime.GC()
+	metrics, err = c.Collect(context.Background())
+	require.NoError(t, err)
+	histogram := metrics[len(metrics)-2]
+	assert.Equal(t, "GCPauseNs", histogram.ID)
+	assert.GreaterOrEqual(t, *histogram.Count, uint64(1))
+}
+
+func TestGCPauseMetrics(t *testing.T) {
+	var rtm runtime.MemStats
+	rtm.NumGC = 3
+	rtm.PauseTotalNs = 2_060_000
+	rtm.PauseNs[0] = 5_000
+	rtm.PauseNs[1] = 55_000
+	rtm.PauseNs[2] = 2_000_000
+
+	histogram := pauseHistogram(recentPauses(&rtm, 2))
+	assert.Equal(t, uint64(2), *histogram.Count)
+	assert.Equal(t, float64(2_055_000), *histogram.Sum)
+	assert.Equal(t, uint64(0), histogram.Buckets[0].Count)
+	assert.Equal(t, uint64(1), histogram.Buckets[2].Count)
+	assert.NoError(t, functions.ValidateMetric(histogram))
+
+	summary := pauseSummary(recentPauses(&rtm, rtm.NumGC), rtm.PauseTotalNs, rtm.NumGC)
+	assert.Equal(t, uint64(3), *summary.Count)
+	assert.Equal(t, []types.Quantile{
+		{Quantile: 0.5, Value: 55_000},
+		{Quantile: 0.9, Value: 2_000_000},
+		{Quantile: 0.99, Value: 2_000_000},
+	}, summary.Quantiles)
+	assert.NoError(t, functions.ValidateMetric(summary))
+}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func labeledGauge(name string, value float64, labels map[string]string) types.Metric {
+	metric := gauge(name, value)
+	metric.Labels = labels
+	return metric
+}
+
+type systemCollector struct {
+	interval time.Duration
+}
+
+// NewSystemCollector reports host memory, per-CPU utilization, load average,
+// disk usage per mount point and network I/O counters per interface. Sources
+// unavailable on the platform are skipped, so the error only says what was
+// left out.
+func NewSystemCollector(interval time.Duration) Collector {
+	return &systemCollector{interval: interval}
+}
+
+func (c *systemCollector) Name() string {
+	return "system"
+}
+
+func (c *systemCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *systemCollector) Collect(ctx context.Context) ([]types.Metric, error) {
+	var metrics []types.Metric
+	var errs []error
+
+	if vm, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		metrics = append(metrics,
+			gauge("TotalMemory", float64(vm.Total)),
+			gauge("FreeMemory", float64(vm.Free)),
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("memory: %w", err))
+	}
+
+	// interval 0 measures utilization since the previous call
+	if percents, err := cpu.PercentWithContext(ctx, 0, true); err == nil {
+		for i, percent := range percents {
+			metrics = append(metrics, gauge(fmt.Sprintf("CPUutilization%d", i+1), percent))
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("cpu: %w", err))
+	}
+
+	if avg, err := load.AvgWithContext(ctx); err == nil {
+		metrics = append(metrics,
+			gauge("Load1", avg.Load1),
+			gauge("Load5", avg.Load5),
+			gauge("Load15", avg.Load15),
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("load: %w", err))
+	}
+
+	if partitions, err := disk.PartitionsWithContext(ctx, false); err == nil {
+		for _, partition := range partitions {
+			usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("disk %s: %w", partition.Mountpoint, err))
+				continue
+			}
+			labels := map[string]string{"mountpoint": partition.Mountpoint}
+			metrics = append(metrics,
+				labeledGauge("DiskTotal", float64(usage.Total), labels),
+				labeledGauge("DiskUsed", float64(usage.Used), labels),
+				labeledGauge("DiskFree", float64(usage.Free), labels),
+			)
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("disk: %w", err))
+	}
+
+	// network counters are cumulative since boot, so they are sent as gauges
+	if counters, err := net.IOCountersWithContext(ctx, true); err == nil {
+		for _, counter := range counters {
+			labels := map[string]string{"interface": counter.Name}
+			metrics = append(metrics,
+				labeledGauge("NetBytesSent", float64(counter.BytesSent), labels),
+				labeledGauge("NetBytesRecv", float64(counter.BytesRecv), labels),
+				labeledGauge("NetPacketsSent", float64(counter.PacketsSent), labels),
+				labeledGauge("NetPacketsRecv", float64(counter.PacketsRecv), labels),
+			)
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("net: %w", err))
+	}
+
+	if len(errs) > 0 {
+		return metrics, fmt.Errorf("partially collected: %v", errs)
+	}
+	return metrics, nil
+}
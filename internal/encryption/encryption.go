@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Header marks request bodies encrypted with Encrypt.
+const (
+	Header    = "X-Content-Encryption"
+	Algorithm = "rsa-oaep-aes256gcm"
+)
+
+var ErrMalformed = errors.New("malformed encrypted message")
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// LoadPublicKey reads an RSA public key in PKIX or PKCS#1 PEM form.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return rsaKey, nil
+}
+
+// LoadPrivateKey reads an RSA private key in PKCS#1 or PKCS#8 PEM form.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+	return rsaKey, nil
+}
+
+// Encrypt seals plaintext with a random AES-256-GCM key, which is encrypted
+// with RSA-OAEP for the key owner. The message layout is:
+// key length (2 bytes) | encrypted key | GCM nonce | ciphertext.
+func Encrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, 2, 2+len(encryptedKey)+len(nonce)+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint16(message, uint16(len(encryptedKey)))
+	message = append(message, encryptedKey...)
+	message = append(message, nonce...)
+	return gcm.Seal(message, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a message produced by Encrypt.
+func Decrypt(key *rsa.PrivateKey, message []byte) ([]byte, error) {
+	if len(message) < 2 {
+		return nil, ErrMalformed
+	}
+	keyLen := int(binary.BigEndian.Uint16(message))
+	message = message[2:]
+	if len(message) < keyLen {
+		return nil, ErrMalformed
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, message[:keyLen], nil)
+	if err != nil {
+		return nil, err
+	}
+	message = message[keyLen:]
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(message) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, message[:gcm.NonceSize()], message[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,67 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	privatePath := filepath.Join(dir, "private.pem")
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+
+	publicPath := filepath.Join(dir, "public.pem")
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644))
+
+	return publicPath, privatePath
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	publicPath, privatePath := writeKeys(t)
+	publicKey, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+	privateKey, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+
+	plaintext := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	message, err := Encrypt(publicKey, plaintext)
+	require.NoError(t, err)
+	assert.NotContains(t, string(message), "Alloc")
+
+	decrypted, err := Decrypt(privateKey, message)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	message[len(message)-1] ^= 0xff
+	_, err = Decrypt(privateKey, message)
+	assert.Error(t, err)
+
+	_, err = Decrypt(privateKey, []byte{0, 10, 1})
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestLoadKeyErrors(t *testing.T) {
+	publicPath, privatePath := writeKeys(t)
+
+	_, err := LoadPrivateKey(publicPath)
+	assert.Error(t, err)
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+	_, err = LoadPublicKey(privatePath)
+	assert.Error(t, err)
+}
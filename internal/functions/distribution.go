@@ -0,0 +1,76 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// ValidateMetric checks that a metric carries the fields its type requires.
+func ValidateMetric(metric types.Metric) error {
+	if metric.ID == "" {
+		return errors.New("metric id is empty")
+	}
+	switch metric.MType {
+	case "counter":
+		if metric.Delta == nil {
+			return fmt.Errorf("counter %q has no delta", metric.ID)
+		}
+	case "gauge":
+		if metric.Value == nil {
+			return fmt.Errorf("gauge %q has no value", metric.ID)
+		}
+	case "histogram":
+		if metric.Sum == nil || metric.Count == nil {
+			return fmt.Errorf("histogram %q has no sum or count", metric.ID)
+		}
+		for i, bucket := range metric.Buckets {
+			if math.IsNaN(bucket.UpperBound) || math.IsInf(bucket.UpperBound, 0) {
+				return fmt.Errorf("histogram %q has non-finite bucket bound", metric.ID)
+			}
+			if i > 0 && bucket.UpperBound <= metric.Buckets[i-1].UpperBound {
+				return fmt.Errorf("histogram %q bucket bounds are not increasing", metric.ID)
+			}
+			if i > 0 && bucket.Count < metric.Buckets[i-1].Count {
+				return fmt.Errorf("histogram %q bucket counts are not cumulative", metric.ID)
+			}
+			if bucket.Count > *metric.Count {
+				return fmt.Errorf("histogram %q bucket count exceeds total count", metric.ID)
+			}
+		}
+	case "summary":
+		if metric.Sum == nil || metric.Count == nil {
+			return fmt.Errorf("summary %q has no sum or count", metric.ID)
+		}
+		for _, quantile := range metric.Quantiles {
+			if quantile.Quantile < 0 || quantile.Quantile > 1 || math.IsNaN(quantile.Quantile) {
+				return fmt.Errorf("summary %q has quantile outside [0, 1]", metric.ID)
+			}
+		}
+	default:
+		return fmt.Errorf("metric %q has unknown type %q", metric.ID, metric.MType)
+	}
+	return nil
+}
+
+func formatDistributionHashMessage(metric types.Metric) string {
+	var sum float64
+	var count uint64
+	if metric.Sum != nil {
+		sum = *metric.Sum
+	}
+	if metric.Count != nil {
+		count = *metric.Count
+	}
+	parts := make([]string, 0, len(metric.Buckets)+len(metric.Quantiles))
+	for _, bucket := range metric.Buckets {
+		parts = append(parts, fmt.Sprintf("%f=%d", bucket.UpperBound, bucket.Count))
+	}
+	for _, quantile := range metric.Quantiles {
+		parts = append(parts, fmt.Sprintf("%f=%f", quantile.Quantile, quantile.Value))
+	}
+	return fmt.Sprintf("%s:%s:%d:%f:%s", metric.ID, metric.MType, count, sum, strings.Join(parts, ","))
+}
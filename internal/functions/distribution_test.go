@@ -0,0 +1,68 @@
+package functions
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestValidateMetric(t *testing.T) {
+	sum, count := 1.0, uint64(2)
+	tests := []struct {
+		name    string
+		metric  types.Metric
+		wantErr bool
+	}{
+		{
+			name: "valid histogram",
+			metric: types.Metric{ID: "h", MType: "histogram", Sum: &sum, Count: &count,
+				Buckets: []types.Bucket{{UpperBound: 1, Count: 1}, {UpperBound: 2, Count: 2}}},
+		},
+		{
+			name: "histogram bounds not increasing",
+			metric: types.Metric{ID: "h", MType: "histogram", Sum: &sum, Count: &count,
+				Buckets: []types.Bucket{{UpperBound: 2, Count: 1}, {UpperBound: 1, Count: 2}}},
+			wantErr: true,
+		},
+		{
+			name: "histogram infinite bound",
+			metric: types.Metric{ID: "h", MType: "histogram", Sum: &sum, Count: &count,
+				Buckets: []types.Bucket{{UpperBound: math.Inf(1), Count: 1}}},
+			wantErr: true,
+		},
+		{
+			name: "histogram bucket over count",
+			metric: types.Metric{ID: "h", MType: "histogram", Sum: &sum, Count: &count,
+				Buckets: []types.Bucket{{UpperBound: 1, Count: 3}}},
+			wantErr: true,
+		},
+		{
+			name: "summary quantile out of range",
+			metric: types.Metric{ID: "s", MType: "summary", Sum: &sum, Count: &count,
+				Quantiles: []types.Quantile{{Quantile: 1.5, Value: 1}}},
+			wantErr: true,
+		},
+		{
+			name:    "summary without count",
+			metric:  types.Metric{ID: "s", MType: "summary", Sum: &sum},
+			wantErr: true,
+		},
+		{
+			name:    "counter without delta",
+			metric:  types.Metric{ID: "c", MType: "counter"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetric(tt.metric)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
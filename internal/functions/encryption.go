@@ -0,0 +1,40 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
+)
+
+// encryptRequest replaces the body of every outgoing request with its
+// encrypted form. It runs on the built http.Request, after signing, so the
+// signature covers the plaintext and every retry is encrypted afresh.
+func encryptRequest(key *rsa.PublicKey) resty.PreRequestHook {
+	return func(c *resty.Client, r *http.Request) error {
+		if r.Body == nil {
+			return nil
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+
+		encrypted, err := encryption.Encrypt(key, body)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(encrypted))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(encrypted)), nil
+		}
+		r.ContentLength = int64(len(encrypted))
+		r.Header.Set(encryption.Header, encryption.Algorithm)
+		return nil
+	}
+}
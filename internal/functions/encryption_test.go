@@ -0,0 +1,53 @@
+package functions
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
+)
+
+func TestEncryptRequest(t *testing.T) {
+	const key = "secret"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	plaintext := []byte(`[{"id":"PollCount"}]`)
+
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		assert.Equal(t, encryption.Algorithm, r.Header.Get(encryption.Header))
+		message, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(message)), r.ContentLength)
+		body, err := encryption.Decrypt(privateKey, message)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, body)
+
+		timestamp := r.Header.Get(SignatureTimestampHeader)
+		nonce := r.Header.Get(SignatureNonceHeader)
+		expected := CreateRequestSignature(key, r.Method, r.URL.Path, timestamp, nonce, body)
+		assert.Equal(t, expected, r.Header.Get(SignatureHeader))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := resty.New().
+		SetBaseURL(server.URL).
+		SetRetryCount(1).
+		AddRetryCondition(func(r *resty.Response, err error) bool {
+			return r.StatusCode() == http.StatusServiceUnavailable
+		})
+	client.OnBeforeRequest(signRequest(key))
+	client.SetPreRequestHook(encryptRequest(&privateKey.PublicKey))
+	_, err = client.R().SetBody(plaintext).Post("/updates")
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+}
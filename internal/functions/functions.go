@@ -1,21 +1,23 @@
 package functions
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/yurchenkosv/metric-service/internal/storage"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"net/http"
 	"os"
-	"runtime"
 	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
 	"github.com/yurchenkosv/metric-service/internal/types"
 )
 
@@ -42,96 +44,122 @@ import (
 
 var mutex sync.Mutex
 
-func appendGaugeMetric(name string, value float64, metrics *types.Metrics, cfg *types.AgentConfig) {
-	gauge := &value
-	hash := ""
-	if cfg.Key != "" {
-		msg := fmt.Sprintf("%s:gauge:%f", name, value)
-		hash = CreateSignedHash(msg, []byte(cfg.Key))
-	}
+// ErrRejected is returned by pushes the server refused for good; sending the
+// same metrics again would not help.
+var ErrRejected = errors.New("metrics rejected by server")
+
+func appendCounterMetric(name string, value int64, metrics *types.Metrics) {
 	metrics.Metric = append(metrics.Metric, types.Metric{
 		ID:    name,
-		MType: "gauge",
-		Value: gauge,
-		Hash:  hash,
+		MType: "counter",
+		Delta: &value,
 	})
 }
 
-func appendCounterMetric(name string, value int64, metrics *types.Metrics, cfg *types.AgentConfig) {
-	counter := &value
-	hash := ""
-	if cfg.Key != "" {
-		msg := fmt.Sprintf("%s:counter:%d", name, value)
-		hash = CreateSignedHash(msg, []byte(cfg.Key))
+// signMetrics attaches the configured labels and hash to every metric. Labels
+// set by a collector take precedence over configured ones.
+func signMetrics(metrics *types.Metrics, cfg *types.AgentConfig) {
+	labels, err := ParseLabels(cfg.Labels)
+	if err != nil {
+		log.Println(err)
+	}
+	for i := range metrics.Metric {
+		if len(labels) > 0 {
+			metrics.Metric[i].Labels = mergeLabels(labels, metrics.Metric[i].Labels)
+		}
+		if cfg.Key != "" {
+			msg := CreateHashMessage(metrics.Metric[i])
+			metrics.Metric[i].Hash = CreateSignedHash(msg, []byte(cfg.Key))
+		}
 	}
-	metrics.Metric = append(metrics.Metric, types.Metric{
-		ID:    name,
-		MType: "counter",
-		Delta: counter,
-		Hash:  hash,
-	})
 }
 
-func CollectMemMetrics(poolCount int, cfg *types.AgentConfig) types.Metrics {
-	var rtm runtime.MemStats
-	var memoryMetrics types.Metrics
-	runtime.ReadMemStats(&rtm)
-	appendGaugeMetric("Alloc", float64(rtm.Alloc), &memoryMetrics, cfg)
-	appendGaugeMetric("BuckHashSys", float64(rtm.BuckHashSys), &memoryMetrics, cfg)
-	appendGaugeMetric("Frees", float64(rtm.Frees), &memoryMetrics, cfg)
-	appendGaugeMetric("GCCPUFraction", float64(rtm.GCCPUFraction), &memoryMetrics, cfg)
-	appendGaugeMetric("GCSys", float64(rtm.GCSys), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapAlloc", float64(rtm.HeapAlloc), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapIdle", float64(rtm.HeapIdle), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapInuse", float64(rtm.HeapInuse), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapObjects", float64(rtm.HeapObjects), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapReleased", float64(rtm.HeapReleased), &memoryMetrics, cfg)
-	appendGaugeMetric("HeapSys", float64(rtm.HeapSys), &memoryMetrics, cfg)
-	appendGaugeMetric("LastGC", float64(rtm.LastGC), &memoryMetrics, cfg)
-	appendGaugeMetric("Lookups", float64(rtm.Lookups), &memoryMetrics, cfg)
-	appendGaugeMetric("MCacheInuse", float64(rtm.MCacheInuse), &memoryMetrics, cfg)
-	appendGaugeMetric("MCacheSys", float64(rtm.MCacheSys), &memoryMetrics, cfg)
-	appendGaugeMetric("MSpanInuse", float64(rtm.MSpanInuse), &memoryMetrics, cfg)
-	appendGaugeMetric("MSpanSys", float64(rtm.MSpanSys), &memoryMetrics, cfg)
-	appendGaugeMetric("Mallocs", float64(rtm.Mallocs), &memoryMetrics, cfg)
-	appendGaugeMetric("NextGC", float64(rtm.NextGC), &memoryMetrics, cfg)
-	appendGaugeMetric("NumForcedGC", float64(rtm.NumForcedGC), &memoryMetrics, cfg)
-	appendGaugeMetric("NumGC", float64(rtm.NumGC), &memoryMetrics, cfg)
-	appendGaugeMetric("OtherSys", float64(rtm.OtherSys), &memoryMetrics, cfg)
-	appendGaugeMetric("PauseTotalNs", float64(rtm.PauseTotalNs), &memoryMetrics, cfg)
-	appendGaugeMetric("StackInuse", float64(rtm.StackInuse), &memoryMetrics, cfg)
-	appendGaugeMetric("StackSys", float64(rtm.StackSys), &memoryMetrics, cfg)
-	appendGaugeMetric("Sys", float64(rtm.Sys), &memoryMetrics, cfg)
-	appendGaugeMetric("TotalAlloc", float64(rtm.TotalAlloc), &memoryMetrics, cfg)
-	appendGaugeMetric("RandomValue", rand.Float64(), &memoryMetrics, cfg)
-	appendCounterMetric("PollCount", int64(poolCount), &memoryMetrics, cfg)
-	return memoryMetrics
+// PrepareReport adds PollCount to collected metrics and attaches the
+// configured labels and hashes.
+func PrepareReport(collected []types.Metric, pollCount int, cfg *types.AgentConfig) types.Metrics {
+	report := types.Metrics{Metric: make([]types.Metric, 0, len(collected)+1)}
+	report.Metric = append(report.Metric, collected...)
+	appendCounterMetric("PollCount", int64(pollCount), &report)
+	signMetrics(&report, cfg)
+	return report
 }
 
-func PushMemMetrics(m types.Metrics, cfg *types.AgentConfig) {
+// NewPushClient builds the HTTP client the agent reuses for every push.
+func NewPushClient(cfg *types.AgentConfig) (*resty.Client, error) {
+	baseURL, err := ServerURL(cfg)
+	if err != nil {
+		return nil, err
+	}
 	client := resty.New()
 	client.SetRetryCount(3).
 		SetRetryWaitTime(2 * time.Second).
 		SetRetryMaxWaitTime(5 * time.Second).
-		SetBaseURL("http://" + cfg.Address)
-	go func() {
-		if len(m.Metric) > 0 {
-			_, err := client.R().
-				SetHeader("Content-Type", "application/json").
-				SetBody(m.Metric).
-				Post("/updates")
-			if err != nil {
-				log.Panic(err)
-			}
+		SetBaseURL(baseURL)
+	if ip, err := OutboundIP(cfg.Address); err == nil {
+		client.SetHeader(RealIPHeader, ip.String())
+	} else {
+		log.Println(err)
+	}
+	if cfg.Scheme == "https" {
+		tlsConfig, err := NewAgentTLSConfig(cfg)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		client.SetTLSClientConfig(tlsConfig)
+	}
+	if cfg.Key != "" && cfg.SignRequests {
+		client.OnBeforeRequest(signRequest(cfg.Key))
+	}
+	if cfg.CryptoKey != "" {
+		key, err := encryption.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		client.SetPreRequestHook(encryptRequest(key))
+	}
+	return client, nil
+}
+
+// PushMemMetrics sends metrics to /updates and waits for the response. ctx
+// bounds the whole push including retries.
+func PushMemMetrics(ctx context.Context, client *resty.Client, m types.Metrics) error {
+	if len(m.Metric) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(m.Metric)
+	if err != nil {
+		return err
+	}
+	resp, err := client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post("/updates")
+	if err != nil {
+		return err
+	}
+	// Only a payload the server can not take is rejected for good; failed
+	// authorization may be fixed on the server and is retried.
+	switch {
+	case resp.StatusCode() == http.StatusBadRequest, resp.StatusCode() == http.StatusConflict:
+		return fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status(), resp.String())
+	case resp.IsError():
+		return fmt.Errorf("push metrics: %s: %s", resp.Status(), resp.String())
+	}
+	return nil
 }
 
-func FlushMetricsToDisk(cfg *types.ServerConfig, m storage.Repository) {
+func FlushMetricsToDisk(ctx context.Context, cfg *types.ServerConfig, m storage.Repository) {
 	if cfg.StoreFile == "" {
 		return
 	}
 
+	metrics, err := m.AsMetrics(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	fileLocation := cfg.StoreFile
 	fileBits := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
 
@@ -141,7 +169,7 @@ func FlushMetricsToDisk(cfg *types.ServerConfig, m storage.Repository) {
 		log.Fatal(err)
 	}
 
-	data, err := json.Marshal(m.AsMetrics())
+	data, err := json.Marshal(metrics)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -155,7 +183,7 @@ func FlushMetricsToDisk(cfg *types.ServerConfig, m storage.Repository) {
 	mutex.Unlock()
 }
 
-func ReadMetricsFromDisk(cnf *types.ServerConfig, repository *storage.Repository) storage.Repository {
+func ReadMetricsFromDisk(ctx context.Context, cnf *types.ServerConfig, repository *storage.Repository) storage.Repository {
 	repo := *repository
 	fileLocation := cnf.StoreFile
 
@@ -172,25 +200,52 @@ func ReadMetricsFromDisk(cnf *types.ServerConfig, repository *storage.Repository
 		return repo
 	}
 
-	for i := range metrics.Metric {
-		metricName := metrics.Metric[i].ID
-		if metrics.Metric[i].MType == "counter" {
-			metricValue := metrics.Metric[i].Delta
-			repo.AddCounter(metricName, types.Counter(*metricValue))
-		}
-		if metrics.Metric[i].MType == "gauge" {
-			metricValue := metrics.Metric[i].Value
-			repo.AddGauge(metricName, types.Gauge(*metricValue))
+	restoreMetrics(ctx, repo, metrics.Metric)
+	return repo
+}
+
+// restoreMetrics stores the valid snapshot metrics in one batch. Metrics
+// without a value can not be stored, so they are logged and left out instead
+// of failing the whole restore.
+func restoreMetrics(ctx context.Context, repo storage.Repository, metrics []types.Metric) {
+	valid := make([]types.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if err := ValidateMetric(metric); err != nil {
+			log.Printf("skipping %s %s from snapshot: %v", metric.MType, types.SeriesKey(metric.ID, metric.Labels), err)
+			continue
 		}
+		valid = append(valid, metric)
+	}
+	if err := repo.InsertMetrics(ctx, valid); err != nil {
+		log.Println(err)
 	}
-	return repo
 }
 
-func Cleanup(mainLoop *time.Ticker, pushLoop *time.Ticker, mainLoopStop chan bool) {
-	mainLoop.Stop()
-	pushLoop.Stop()
-	mainLoopStop <- true
-	println("Program exit")
+// CreateHashMessage builds the message signed by CreateSignedHash for a metric:
+// "id:type:value" for counters and gauges, "id:type:count:sum:bound=value,..."
+// for histograms and summaries, followed by ":labels" for labeled metrics.
+func CreateHashMessage(metric types.Metric) string {
+	var msg string
+	switch metric.MType {
+	case "counter":
+		var delta int64
+		if metric.Delta != nil {
+			delta = *metric.Delta
+		}
+		msg = fmt.Sprintf("%s:counter:%d", metric.ID, delta)
+	case "gauge":
+		var value float64
+		if metric.Value != nil {
+			value = *metric.Value
+		}
+		msg = fmt.Sprintf("%s:gauge:%f", metric.ID, value)
+	case "histogram", "summary":
+		msg = formatDistributionHashMessage(metric)
+	}
+	if len(metric.Labels) > 0 {
+		msg += ":" + types.FormatLabels(metric.Labels)
+	}
+	return msg
 }
 
 func CreateSignedHash(msg string, key []byte) string {
@@ -1,48 +1,205 @@
 package functions
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/storage"
 	"github.com/yurchenkosv/metric-service/internal/types"
 )
 
-func TestCollectMemMetrics(t *testing.T) {
-	pollCount := int64(1)
-	type want struct {
-		metrics       types.Metrics
-		metricsLength int
+func testMetrics() []types.Metric {
+	value := 1.5
+	return []types.Metric{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "DiskFree", MType: "gauge", Value: &value, Labels: map[string]string{"mountpoint": "/"}},
 	}
+}
+
+func TestPrepareReport(t *testing.T) {
 	tests := []struct {
 		name      string
 		pollCount int
 		cfg       types.AgentConfig
-		want      want
+		labels    []map[string]string
 	}{
 		{
-			name:      "Should return map with metrics",
+			name:      "should append PollCount",
 			pollCount: 1,
 			cfg:       types.AgentConfig{},
-			want: want{
-				metricsLength: 29,
-				metrics: types.Metrics{Metric: []types.Metric{
-					{
-						ID:    "PollCount",
-						MType: "counter",
-						Delta: &pollCount,
-						Value: nil,
-					},
-				}},
+			labels:    []map[string]string{nil, {"mountpoint": "/"}, nil},
+		},
+		{
+			name:      "should attach configured labels and hashes",
+			pollCount: 5,
+			cfg:       types.AgentConfig{Key: "secret", Labels: "host=a,mountpoint=none"},
+			labels: []map[string]string{
+				{"host": "a", "mountpoint": "none"},
+				{"host": "a", "mountpoint": "/"},
+				{"host": "a", "mountpoint": "none"},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := CollectMemMetrics(tt.pollCount, &tt.cfg)
-			assert.IsType(t, types.Metrics{}, result)
-			assert.NotEmpty(t, result.Metric)
-			assert.Equal(t, len(result.Metric), tt.want.metricsLength)
-			assert.Equal(t, int64(tt.pollCount), *result.Metric[28].Delta)
+			result := PrepareReport(testMetrics(), tt.pollCount, &tt.cfg)
+			require.Len(t, result.Metric, 3)
+			pollCount := result.Metric[2]
+			assert.Equal(t, "PollCount", pollCount.ID)
+			assert.Equal(t, int64(tt.pollCount), *pollCount.Delta)
+			for i, metric := range result.Metric {
+				assert.Equal(t, tt.labels[i], metric.Labels, metric.ID)
+				if tt.cfg.Key != "" {
+					assert.Equal(t, CreateSignedHash(CreateHashMessage(metric), []byte(tt.cfg.Key)), metric.Hash)
+				} else {
+					assert.Empty(t, metric.Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateHashMessage(t *testing.T) {
+	counter := int64(3)
+	gauge := 1.5
+	tests := []struct {
+		name   string
+		metric types.Metric
+		want   string
+	}{
+		{
+			name:   "counter without labels",
+			metric: types.Metric{ID: "PollCount", MType: "counter", Delta: &counter},
+			want:   "PollCount:counter:3",
+		},
+		{
+			name:   "gauge without labels",
+			metric: types.Metric{ID: "Alloc", MType: "gauge", Value: &gauge},
+			want:   "Alloc:gauge:1.500000",
+		},
+		{
+			name: "labels are sorted and quoted",
+			metric: types.Metric{
+				ID:     "Alloc",
+				MType:  "gauge",
+				Value:  &gauge,
+				Labels: map[string]string{"service": "api", "host": "42"},
+			},
+			want: `Alloc:gauge:1.500000:host="42",service="api"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CreateHashMessage(tt.metric))
+		})
+	}
+}
+
+func TestPushMemMetrics(t *testing.T) {
+	var received []types.Metric
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	cfg := &types.AgentConfig{Address: strings.TrimPrefix(server.URL, "http://")}
+	client, err := NewPushClient(cfg)
+	require.NoError(t, err)
+	metrics := PrepareReport(testMetrics(), 1, cfg)
+
+	err = PushMemMetrics(context.Background(), client, metrics)
+	assert.NoError(t, err)
+	assert.Len(t, received, len(metrics.Metric), "push must complete before returning")
+
+	err = PushMemMetrics(context.Background(), client, types.Metrics{Metric: metrics.Metric[:1]})
+	assert.Error(t, err)
+
+	assert.NoError(t, PushMemMetrics(context.Background(), client, types.Metrics{}))
+}
+
+func TestPushMemMetricsRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		rejected bool
+	}{
+		{name: "bad request is rejected", status: http.StatusBadRequest, rejected: true},
+		{name: "conflict is rejected", status: http.StatusConflict, rejected: true},
+		{name: "unauthorized is retried", status: http.StatusUnauthorized},
+		{name: "forbidden is retried", status: http.StatusForbidden},
+		{name: "too many requests is retried", status: http.StatusTooManyRequests},
+		{name: "server error is retried", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			cfg := &types.AgentConfig{Address: strings.TrimPrefix(server.URL, "http://")}
+			client, err := NewPushClient(cfg)
+			require.NoError(t, err)
+
+			err = PushMemMetrics(context.Background(), client, PrepareReport(testMetrics(), 1, cfg))
+			require.Error(t, err)
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrRejected))
 		})
 	}
 }
+
+func TestPushMemMetricsDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	cfg := &types.AgentConfig{Address: strings.TrimPrefix(server.URL, "http://")}
+	client, err := NewPushClient(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = PushMemMetrics(ctx, client, PrepareReport(testMetrics(), 1, cfg))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), 2*time.Second)
+}
+
+func TestReadMetricsFromDisk(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metrics.json")
+	snapshot := `{"Metric":[
+		{"id":"A","type":"gauge","value":1},
+		{"id":"X","type":"counter","delta":2},
+		{"id":"X","type":"gauge","value":3},
+		{"id":"Y","type":"counter"}
+	]}`
+	require.NoError(t, os.WriteFile(file, []byte(snapshot), 0600))
+
+	repo := storage.NewMapStorage()
+	repo = ReadMetricsFromDisk(context.Background(), &types.ServerConfig{StoreFile: file}, &repo)
+
+	metrics, err := repo.AsMetrics(context.Background())
+	require.NoError(t, err)
+	a, xCounter, xGauge := 1.0, int64(2), 3.0
+	assert.ElementsMatch(t, []types.Metric{
+		{ID: "A", MType: "gauge", Value: &a},
+		{ID: "X", MType: "counter", Delta: &xCounter},
+		{ID: "X", MType: "gauge", Value: &xGauge},
+	}, metrics.Metric)
+}
@@ -0,0 +1,83 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	pb "github.com/yurchenkosv/metric-service/internal/proto"
+	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const grpcPushTimeout = 10 * time.Second
+
+// CheckGRPCAgentConfig fails if the agent pushes over gRPC with request
+// encryption or signing, which only the http transport implements.
+func CheckGRPCAgentConfig(cfg *types.AgentConfig) error {
+	if cfg.Transport != "grpc" {
+		return nil
+	}
+	if cfg.CryptoKey != "" {
+		return fmt.Errorf("-crypto-key is not supported with -transport grpc")
+	}
+	if cfg.SignRequests {
+		return fmt.Errorf("-sign-requests is not supported with -transport grpc")
+	}
+	return nil
+}
+
+// CheckGRPCServerConfig fails if the server serves gRPC while requiring
+// request signatures, which gRPC updates do not carry.
+func CheckGRPCServerConfig(cfg *types.ServerConfig) error {
+	if cfg.GRPCAddress != "" && cfg.RequireSignature {
+		return fmt.Errorf("-require-signature is not supported with -grpc-address")
+	}
+	return nil
+}
+
+// NewGRPCConn connects to the server gRPC API, over TLS when the agent uses
+// the https scheme.
+func NewGRPCConn(cfg *types.AgentConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.Scheme == "https" {
+		tlsConfig, err := NewAgentTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.Dial(cfg.Address, grpc.WithTransportCredentials(creds))
+}
+
+// PushMemMetricsGRPC sends collected metrics in a single UpdateMetrics call.
+func PushMemMetricsGRPC(ctx context.Context, client pb.MetricsClient, m types.Metrics, cfg *types.AgentConfig) error {
+	if len(m.Metric) == 0 {
+		return nil
+	}
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(m.Metric))}
+	for _, metric := range m.Metric {
+		req.Metrics = append(req.Metrics, pb.FromMetric(metric))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, grpcPushTimeout)
+	defer cancel()
+	if ip, err := OutboundIP(cfg.Address); err == nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(RealIPHeader), ip.String())
+	} else {
+		log.Println(err)
+	}
+	_, err := client.UpdateMetrics(ctx, req)
+	// As over http, only an invalid payload is rejected for good.
+	if status.Code(err) == codes.InvalidArgument {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
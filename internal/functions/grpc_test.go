@@ -0,0 +1,93 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "github.com/yurchenkosv/metric-service/internal/proto"
+	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingMetricsClient answers every UpdateMetrics call with err.
+type failingMetricsClient struct {
+	pb.MetricsClient
+	err error
+}
+
+func (c failingMetricsClient) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest, opts ...grpc.CallOption) (*pb.UpdateMetricsResponse, error) {
+	return nil, c.err
+}
+
+func TestCheckGRPCAgentConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     types.AgentConfig
+		wantErr bool
+	}{
+		{name: "should allow plain grpc", cfg: types.AgentConfig{Transport: "grpc", Key: "secret"}},
+		{name: "should allow encryption over http", cfg: types.AgentConfig{Transport: "http", CryptoKey: "public.pem", SignRequests: true}},
+		{name: "should reject encryption over grpc", cfg: types.AgentConfig{Transport: "grpc", CryptoKey: "public.pem"}, wantErr: true},
+		{name: "should reject request signing over grpc", cfg: types.AgentConfig{Transport: "grpc", Key: "secret", SignRequests: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckGRPCAgentConfig(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckGRPCServerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     types.ServerConfig
+		wantErr bool
+	}{
+		{name: "should allow grpc", cfg: types.ServerConfig{GRPCAddress: "localhost:3200", Key: "secret"}},
+		{name: "should allow required signature without grpc", cfg: types.ServerConfig{Key: "secret", RequireSignature: true}},
+		{name: "should reject required signature with grpc", cfg: types.ServerConfig{GRPCAddress: "localhost:3200", Key: "secret", RequireSignature: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckGRPCServerConfig(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPushMemMetricsGRPCRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     codes.Code
+		rejected bool
+	}{
+		{name: "invalid argument is rejected", code: codes.InvalidArgument, rejected: true},
+		{name: "unauthenticated is retried", code: codes.Unauthenticated},
+		{name: "permission denied is retried", code: codes.PermissionDenied},
+		{name: "unavailable is retried", code: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &types.AgentConfig{Address: "localhost:3200"}
+			client := failingMetricsClient{err: status.Error(tt.code, "failed")}
+
+			err := PushMemMetricsGRPC(context.Background(), client, PrepareReport(testMetrics(), 1, cfg), cfg)
+			require.Error(t, err)
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrRejected))
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+const (
+	outboxExt = ".json"
+	// outboxSendingExt is appended to reports a replay is sending, so they are
+	// never sent twice.
+	outboxSendingExt = ".sending"
+)
+
+// errNotRemoved is returned by Replay when delivered reports could not be
+// removed. They are not sent again.
+var errNotRemoved = errors.New("delivered report left in outbox")
+
+// Outbox keeps reports the server could not take on disk, one file per
+// report, and replays them once it is reachable again. Files older than
+// OutboxMaxAge are dropped, as are the oldest ones when the outbox grows
+// beyond OutboxMaxSize bytes.
+type Outbox struct {
+	cfg       *types.AgentConfig
+	mutex     sync.Mutex
+	replaying int32
+	seq       uint64
+	now       func() time.Time
+	remove    func(name string) error
+}
+
+func NewOutbox(cfg *types.AgentConfig) (*Outbox, error) {
+	if err := os.MkdirAll(cfg.OutboxDir, 0700); err != nil {
+		return nil, err
+	}
+	return &Outbox{cfg: cfg, now: time.Now, remove: os.Remove}, nil
+}
+
+// Push wraps push so that reports it fails to deliver are kept in the
+// outbox. While the outbox is not empty new reports are queued behind the
+// kept ones and all of them are replayed together.
+func (o *Outbox) Push(push PushFunc) PushFunc {
+	return func(ctx context.Context, m types.Metrics) error {
+		files, err := o.files()
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			err = push(ctx, m)
+			if err == nil || errors.Is(err, ErrRejected) {
+				return err
+			}
+			log.Printf("keeping report in outbox: %v", err)
+			return o.Put(m)
+		}
+		if err = o.Put(m); err != nil {
+			return err
+		}
+		err = o.Replay(ctx, push)
+		if errors.Is(err, errNotRemoved) {
+			// m was delivered with the kept reports.
+			log.Println(err)
+			return nil
+		}
+		// A rejection is returned too, so the caller knows m was dropped.
+		return err
+	}
+}
+
+// Put stores a report at the end of the outbox.
+func (o *Outbox) Put(m types.Metrics) error {
+	if len(m.Metric) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(m.Metric)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	name := fmt.Sprintf("%020d-%06d%s", o.now().UnixNano(), atomic.AddUint64(&o.seq, 1)%1000000, outboxExt)
+	tmp, err := ioutil.TempFile(o.cfg.OutboxDir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(o.cfg.OutboxDir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return o.trim()
+}
+
+// Replay sends every kept report as a single report: counter and histogram
+// deltas are summed and the latest snapshot of other metrics wins, so a
+// series is counted once however many reports were kept. Reports are marked
+// as being sent first and are put back only if sending fails; once the server
+// accepted them, or rejected them for good, they are removed. A report that
+// can not be removed then, or that was being sent when the agent stopped, is
+// dropped by the next replay rather than sent twice. Replay returns the
+// rejection after dropping rejected reports, and nil without sending if
+// another replay is in progress.
+func (o *Outbox) Replay(ctx context.Context, push PushFunc) error {
+	if !atomic.CompareAndSwapInt32(&o.replaying, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&o.replaying, 0)
+
+	o.mutex.Lock()
+	err := o.dropSending()
+	if err == nil {
+		err = o.trim()
+	}
+	var names []string
+	if err == nil {
+		names, err = o.claim()
+	}
+	o.mutex.Unlock()
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	merged, err := o.merge(names)
+	if err == nil {
+		signMetrics(&merged, o.cfg)
+		err = push(ctx, merged)
+	}
+	rejected := errors.Is(err, ErrRejected)
+	switch {
+	case rejected:
+		log.Printf("dropping %d reports from outbox: %v", len(names), err)
+	case err != nil:
+		log.Printf("replaying %d reports from outbox: %v", len(names), err)
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		return o.release(names)
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	var removeErr error
+	for _, name := range names {
+		if err := o.remove(filepath.Join(o.cfg.OutboxDir, name+outboxSendingExt)); err != nil && !os.IsNotExist(err) {
+			removeErr = fmt.Errorf("%w: %v", errNotRemoved, err)
+			break
+		}
+	}
+	if rejected {
+		if removeErr != nil {
+			log.Println(removeErr)
+		}
+		return err
+	}
+	return removeErr
+}
+
+// claim marks every kept report as being sent and returns their names,
+// oldest first. The caller holds the mutex.
+func (o *Outbox) claim() ([]string, error) {
+	files, err := o.files()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(o.cfg.OutboxDir, file.Name())
+		if err = os.Rename(path, path+outboxSendingExt); err != nil {
+			if releaseErr := o.release(names); releaseErr != nil {
+				log.Println(releaseErr)
+			}
+			return nil, err
+		}
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
+
+// release puts reports marked by claim back into the outbox. The caller
+// holds the mutex.
+func (o *Outbox) release(names []string) error {
+	for _, name := range names {
+		path := filepath.Join(o.cfg.OutboxDir, name)
+		if err := os.Rename(path+outboxSendingExt, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSending removes reports left marked as being sent by an earlier replay.
+// They may have been delivered already. The caller holds the mutex.
+func (o *Outbox) dropSending() error {
+	entries, err := ioutil.ReadDir(o.cfg.OutboxDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), outboxExt+outboxSendingExt) {
+			continue
+		}
+		log.Printf("dropping report %s left from an earlier replay", entry.Name())
+		if err := o.remove(filepath.Join(o.cfg.OutboxDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of kept reports.
+func (o *Outbox) Len() int {
+	files, err := o.files()
+	if err != nil {
+		log.Println(err)
+	}
+	return len(files)
+}
+
+// merge reads reports marked by claim.
+func (o *Outbox) merge(names []string) (types.Metrics, error) {
+	pending := make(map[string]types.Metric)
+	var order []string
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(o.cfg.OutboxDir, name+outboxSendingExt))
+		if err != nil {
+			return types.Metrics{}, err
+		}
+		var metrics []types.Metric
+		if err = json.Unmarshal(data, &metrics); err != nil {
+			log.Printf("skipping malformed outbox file %s: %v", name, err)
+			continue
+		}
+		for _, metric := range metrics {
+			key := metric.MType + ":" + types.SeriesKey(metric.ID, metric.Labels)
+			stored, ok := pending[key]
+			if !ok {
+				order = append(order, key)
+				pending[key] = metric
+				continue
+			}
+			pending[key] = types.AccumulateMetric(stored, metric)
+		}
+	}
+	merged := types.Metrics{Metric: make([]types.Metric, 0, len(order))}
+	for _, key := range order {
+		merged.Metric = append(merged.Metric, pending[key])
+	}
+	return merged, nil
+}
+
+// files lists kept reports, oldest first.
+func (o *Outbox) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(o.cfg.OutboxDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasSuffix(entry.Name(), outboxExt) && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, entry)
+		}
+	}
+	return files, nil
+}
+
+// trim removes expired reports and the oldest ones beyond the size limit.
+// The caller holds the mutex.
+func (o *Outbox) trim() error {
+	files, err := o.files()
+	if err != nil {
+		return err
+	}
+	var kept []os.FileInfo
+	var size int64
+	for _, entry := range files {
+		if o.cfg.OutboxMaxAge > 0 && o.now().Sub(outboxCreated(entry.Name())) > o.cfg.OutboxMaxAge {
+			log.Printf("dropping expired report %s from outbox", entry.Name())
+			if err := os.Remove(filepath.Join(o.cfg.OutboxDir, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, entry)
+		size += entry.Size()
+	}
+	for len(kept) > 0 && o.cfg.OutboxMaxSize > 0 && size > o.cfg.OutboxMaxSize {
+		log.Printf("outbox is over %d bytes, dropping report %s", o.cfg.OutboxMaxSize, kept[0].Name())
+		if err := os.Remove(filepath.Join(o.cfg.OutboxDir, kept[0].Name())); err != nil {
+			return err
+		}
+		size -= kept[0].Size()
+		kept = kept[1:]
+	}
+	return nil
+}
+
+// outboxCreated reads the creation time encoded in a report file name.
+func outboxCreated(name string) time.Time {
+	nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
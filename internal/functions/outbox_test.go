@@ -0,0 +1,138 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func outboxReport(delta int64, value float64) types.Metrics {
+	return types.Metrics{Metric: []types.Metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	}}
+}
+
+func TestOutboxReplay(t *testing.T) {
+	cfg := &types.AgentConfig{OutboxDir: t.TempDir(), Key: "key"}
+	outbox, err := NewOutbox(cfg)
+	require.NoError(t, err)
+
+	var pushed []types.Metrics
+	serverErr := errors.New("connection refused")
+	push := outbox.Push(func(ctx context.Context, m types.Metrics) error {
+		if serverErr != nil {
+			return serverErr
+		}
+		pushed = append(pushed, m)
+		return nil
+	})
+
+	require.NoError(t, push(context.Background(), outboxReport(1, 1)))
+	require.NoError(t, push(context.Background(), outboxReport(2, 2)))
+	assert.Equal(t, 2, outbox.Len())
+	assert.Empty(t, pushed)
+
+	serverErr = nil
+	require.NoError(t, push(context.Background(), outboxReport(4, 3)))
+	assert.Equal(t, 0, outbox.Len())
+	require.Len(t, pushed, 1)
+	require.Len(t, pushed[0].Metric, 2)
+	counter, gauge := pushed[0].Metric[0], pushed[0].Metric[1]
+	assert.Equal(t, int64(7), *counter.Delta)
+	assert.Equal(t, CreateSignedHash(CreateHashMessage(counter), []byte(cfg.Key)), counter.Hash)
+	assert.Equal(t, 3.0, *gauge.Value)
+
+	require.NoError(t, push(context.Background(), outboxReport(1, 4)))
+	require.Len(t, pushed, 2)
+	assert.Equal(t, int64(1), *pushed[1].Metric[0].Delta)
+}
+
+func TestOutboxRejected(t *testing.T) {
+	outbox, err := NewOutbox(&types.AgentConfig{OutboxDir: t.TempDir()})
+	require.NoError(t, err)
+	push := outbox.Push(func(ctx context.Context, m types.Metrics) error {
+		return ErrRejected
+	})
+
+	assert.ErrorIs(t, push(context.Background(), outboxReport(1, 1)), ErrRejected)
+	assert.Equal(t, 0, outbox.Len())
+
+	require.NoError(t, outbox.Put(outboxReport(1, 1)))
+	assert.ErrorIs(t, push(context.Background(), outboxReport(1, 1)), ErrRejected, "the report queued behind kept ones is dropped too")
+	assert.Equal(t, 0, outbox.Len())
+
+	require.NoError(t, outbox.Put(outboxReport(1, 1)))
+	outbox.remove = func(name string) error { return errors.New("read-only file system") }
+	assert.ErrorIs(t, push(context.Background(), outboxReport(1, 1)), ErrRejected)
+}
+
+func TestOutboxNotRemoved(t *testing.T) {
+	outbox, err := NewOutbox(&types.AgentConfig{OutboxDir: t.TempDir()})
+	require.NoError(t, err)
+	var pushed []types.Metrics
+	push := func(ctx context.Context, m types.Metrics) error {
+		pushed = append(pushed, m)
+		return nil
+	}
+	require.NoError(t, outbox.Put(outboxReport(1, 1)))
+	require.NoError(t, outbox.Put(outboxReport(2, 2)))
+
+	outbox.remove = func(name string) error { return errors.New("read-only file system") }
+	assert.ErrorIs(t, outbox.Replay(context.Background(), push), errNotRemoved)
+	require.Len(t, pushed, 1)
+	assert.Equal(t, 0, outbox.Len())
+
+	outbox.remove = os.Remove
+	require.NoError(t, outbox.Replay(context.Background(), push))
+	assert.Len(t, pushed, 1, "delivered reports are not sent again")
+	entries, err := os.ReadDir(outbox.cfg.OutboxDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOutboxLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     types.AgentConfig
+		advance time.Duration
+		want    int
+	}{
+		{
+			name: "unlimited",
+			want: 3,
+		},
+		{
+			name:    "expired",
+			cfg:     types.AgentConfig{OutboxMaxAge: time.Minute},
+			advance: 2 * time.Minute,
+			want:    1,
+		},
+		{
+			name: "oversized",
+			cfg:  types.AgentConfig{OutboxMaxSize: 100},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.OutboxDir = t.TempDir()
+			outbox, err := NewOutbox(&tt.cfg)
+			require.NoError(t, err)
+			now := time.Now()
+			outbox.now = func() time.Time { return now }
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, outbox.Put(outboxReport(1, 1)))
+				now = now.Add(tt.advance)
+			}
+			assert.Equal(t, tt.want, outbox.Len())
+		})
+	}
+}
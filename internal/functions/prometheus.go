@@ -0,0 +1,195 @@
+package functions
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// SanitizePrometheusName replaces every character that is not allowed in a
+// Prometheus metric or label name with an underscore.
+func SanitizePrometheusName(name string) string {
+	var builder strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			builder.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				builder.WriteRune('_')
+			}
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+	if builder.Len() == 0 {
+		return "_"
+	}
+	return builder.String()
+}
+
+// ParseLabels reads labels in "key=value,key=value" form.
+func ParseLabels(labels string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(labels) == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(labels, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !types.ValidLabelName(strings.TrimSpace(kv[0])) {
+			return nil, fmt.Errorf("malformed label %q", pair)
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
+// LabelsFromQuery uses query parameters as labels.
+func LabelsFromQuery(query url.Values) map[string]string {
+	if len(query) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(query))
+	for k := range query {
+		labels[k] = query.Get(k)
+	}
+	return labels
+}
+
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, SanitizePrometheusName(k), escaper.Replace(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func mergeLabels(constLabels map[string]string, labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return constLabels
+	}
+	merged := make(map[string]string, len(constLabels)+len(labels))
+	for k, v := range constLabels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+func formatPrometheusFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func withLabel(labels map[string]string, key string, value string) map[string]string {
+	extended := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		extended[k] = v
+	}
+	extended[key] = value
+	return extended
+}
+
+// prometheusSamples renders sample lines of a metric, or nil if the metric
+// has no value to expose.
+func prometheusSamples(name string, labels map[string]string, metric types.Metric) []string {
+	sample := func(suffix string, labels map[string]string, value string) string {
+		return name + suffix + formatPrometheusLabels(labels) + " " + value
+	}
+	switch {
+	case metric.MType == "counter" && metric.Delta != nil:
+		return []string{sample("", labels, strconv.FormatInt(*metric.Delta, 10))}
+	case metric.MType == "gauge" && metric.Value != nil:
+		return []string{sample("", labels, formatPrometheusFloat(*metric.Value))}
+	case metric.MType == "histogram" && metric.Sum != nil && metric.Count != nil:
+		var lines []string
+		for _, bucket := range metric.Buckets {
+			le := withLabel(labels, "le", formatPrometheusFloat(bucket.UpperBound))
+			lines = append(lines, sample("_bucket", le, strconv.FormatUint(bucket.Count, 10)))
+		}
+		count := strconv.FormatUint(*metric.Count, 10)
+		lines = append(lines,
+			sample("_bucket", withLabel(labels, "le", "+Inf"), count),
+			sample("_sum", labels, formatPrometheusFloat(*metric.Sum)),
+			sample("_count", labels, count),
+		)
+		return lines
+	case metric.MType == "summary" && metric.Sum != nil && metric.Count != nil:
+		var lines []string
+		for _, quantile := range metric.Quantiles {
+			q := withLabel(labels, "quantile", formatPrometheusFloat(quantile.Quantile))
+			lines = append(lines, sample("", q, formatPrometheusFloat(quantile.Value)))
+		}
+		lines = append(lines,
+			sample("_sum", labels, formatPrometheusFloat(*metric.Sum)),
+			sample("_count", labels, strconv.FormatUint(*metric.Count, 10)),
+		)
+		return lines
+	}
+	return nil
+}
+
+// FormatPrometheus renders metrics in the Prometheus text exposition format.
+// constLabels are attached to every sample; labels of the metric itself take
+// precedence over them. Metrics are grouped by the sanitized name, so IDs that
+// sanitize to the same name form one family.
+func FormatPrometheus(metrics types.Metrics, constLabels map[string]string) string {
+	sorted := make([]types.Metric, len(metrics.Metric))
+	copy(sorted, metrics.Metric)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		nameI, nameJ := SanitizePrometheusName(sorted[i].ID), SanitizePrometheusName(sorted[j].ID)
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var builder strings.Builder
+	declared := make(map[string]string)
+	exposed := make(map[string]bool)
+	for _, metric := range sorted {
+		name := SanitizePrometheusName(metric.ID)
+		labels := mergeLabels(constLabels, metric.Labels)
+		lines := prometheusSamples(name, labels, metric)
+		if lines == nil {
+			continue
+		}
+
+		if mType, ok := declared[name]; ok && mType != metric.MType {
+			log.Printf("skipping %s %q: name already exposed as %s", metric.MType, metric.ID, mType)
+			continue
+		} else if !ok {
+			declared[name] = metric.MType
+			fmt.Fprintf(&builder, "# TYPE %s %s\n", name, metric.MType)
+		}
+		series := name + formatPrometheusLabels(labels)
+		if exposed[series] {
+			log.Printf("skipping %s %q: series %s already exposed", metric.MType, metric.ID, series)
+			continue
+		}
+		exposed[series] = true
+		for _, line := range lines {
+			builder.WriteString(line + "\n")
+		}
+	}
+	return builder.String()
+}
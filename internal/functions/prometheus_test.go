@@ -0,0 +1,131 @@
+package functions
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestSanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid name is kept", in: "HeapAlloc", want: "HeapAlloc"},
+		{name: "invalid characters replaced", in: "cpu.usage-percent", want: "cpu_usage_percent"},
+		{name: "leading digit prefixed", in: "1min", want: "_1min"},
+		{name: "empty name", in: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizePrometheusName(tt.in))
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("instance=edge-1, env=prod")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"instance": "edge-1", "env": "prod"}, labels)
+
+	labels, err = ParseLabels("")
+	require.NoError(t, err)
+	assert.Empty(t, labels)
+
+	_, err = ParseLabels("broken")
+	assert.Error(t, err)
+}
+
+func TestFormatPrometheus(t *testing.T) {
+	counter := int64(5)
+	gauge := 0.25
+	inf := math.Inf(1)
+	tests := []struct {
+		name    string
+		metrics types.Metrics
+		labels  map[string]string
+		want    string
+	}{
+		{
+			name: "counter and gauge",
+			metrics: types.Metrics{Metric: []types.Metric{
+				{ID: "PollCount", MType: "counter", Delta: &counter},
+				{ID: "Alloc", MType: "gauge", Value: &gauge},
+			}},
+			want: "# TYPE Alloc gauge\nAlloc 0.25\n# TYPE PollCount counter\nPollCount 5\n",
+		},
+		{
+			name: "const labels are escaped and sorted",
+			metrics: types.Metrics{Metric: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: &gauge},
+			}},
+			labels: map[string]string{"job": "agent", "host": `a"b`},
+			want:   "# TYPE Alloc gauge\nAlloc{host=\"a\\\"b\",job=\"agent\"} 0.25\n",
+		},
+		{
+			name: "conflicting types with same name are skipped",
+			metrics: types.Metrics{Metric: []types.Metric{
+				{ID: "Same", MType: "counter", Delta: &counter},
+				{ID: "Same", MType: "gauge", Value: &inf},
+			}},
+			want: "# TYPE Same counter\nSame 5\n",
+		},
+		{
+			name: "ids sanitized to the same name form one family",
+			metrics: types.Metrics{Metric: []types.Metric{
+				{ID: "a.b", MType: "gauge", Value: &gauge},
+				{ID: "a.c", MType: "gauge", Value: &gauge},
+				{ID: "a_b", MType: "gauge", Value: &gauge, Labels: map[string]string{"disk": "sda"}},
+			}},
+			want: "# TYPE a_b gauge\na_b 0.25\na_b{disk=\"sda\"} 0.25\n# TYPE a_c gauge\na_c 0.25\n",
+		},
+		{
+			name: "metrics without value are skipped",
+			metrics: types.Metrics{Metric: []types.Metric{
+				{ID: "Empty", MType: "gauge"},
+			}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FormatPrometheus(tt.metrics, tt.labels))
+		})
+	}
+}
+
+func TestFormatPrometheusDistributions(t *testing.T) {
+	sum, count := 2.5, uint64(3)
+	metrics := types.Metrics{Metric: []types.Metric{
+		{
+			ID:      "Latency",
+			MType:   "histogram",
+			Sum:     &sum,
+			Count:   &count,
+			Buckets: []types.Bucket{{UpperBound: 0.5, Count: 1}, {UpperBound: 1, Count: 2}},
+		},
+		{
+			ID:        "Pause",
+			MType:     "summary",
+			Sum:       &sum,
+			Count:     &count,
+			Quantiles: []types.Quantile{{Quantile: 0.5, Value: 0.7}},
+		},
+	}}
+
+	want := "# TYPE Latency histogram\n" +
+		"Latency_bucket{le=\"0.5\"} 1\n" +
+		"Latency_bucket{le=\"1\"} 2\n" +
+		"Latency_bucket{le=\"+Inf\"} 3\n" +
+		"Latency_sum 2.5\n" +
+		"Latency_count 3\n" +
+		"# TYPE Pause summary\n" +
+		"Pause{quantile=\"0.5\"} 0.7\n" +
+		"Pause_sum 2.5\n" +
+		"Pause_count 3\n"
+	assert.Equal(t, want, FormatPrometheus(metrics, nil))
+}
@@ -0,0 +1,47 @@
+package functions
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// ParseTimestamp reads a time given either in RFC3339 or as unix seconds.
+func ParseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// ParseStep reads a step given either as a Go duration or as seconds.
+func ParseStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// AlignSamples evaluates the series at start, start+step, ... up to end.
+// Each point takes the latest sample within the step preceding it; steps
+// without samples are skipped. Samples must be in chronological order.
+func AlignSamples(samples []types.Sample, start time.Time, end time.Time, step time.Duration) []types.Sample {
+	points := []types.Sample{}
+	i := 0
+	for ts := start; !ts.After(end); ts = ts.Add(step) {
+		var last *types.Sample
+		for i < len(samples) && !samples[i].Timestamp.After(ts) {
+			if samples[i].Timestamp.After(ts.Add(-step)) {
+				last = &samples[i]
+			}
+			i++
+		}
+		if last != nil {
+			points = append(points, types.Sample{Timestamp: ts, Value: last.Value})
+		}
+	}
+	return points
+}
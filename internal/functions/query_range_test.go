@@ -0,0 +1,50 @@
+package functions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestAlignSamples(t *testing.T) {
+	start := time.Unix(1000, 0)
+	samples := []types.Sample{
+		{Timestamp: start.Add(-30 * time.Second), Value: 1},
+		{Timestamp: start.Add(5 * time.Second), Value: 2},
+		{Timestamp: start.Add(8 * time.Second), Value: 3},
+		{Timestamp: start.Add(35 * time.Second), Value: 4},
+	}
+
+	points := AlignSamples(samples, start, start.Add(40*time.Second), 10*time.Second)
+
+	assert.Equal(t, []types.Sample{
+		{Timestamp: start.Add(10 * time.Second), Value: 3},
+		{Timestamp: start.Add(40 * time.Second), Value: 4},
+	}, points)
+}
+
+func TestParseTimestamp(t *testing.T) {
+	ts, err := ParseTimestamp("1000.5")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1000, 500000000), ts)
+
+	ts, err = ParseTimestamp("2022-06-01T10:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC), ts)
+
+	_, err = ParseTimestamp("yesterday")
+	assert.Error(t, err)
+}
+
+func TestParseStep(t *testing.T) {
+	step, err := ParseStep("15")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, step)
+
+	step, err = ParseStep("1m")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, step)
+}
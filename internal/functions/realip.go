@@ -0,0 +1,20 @@
+package functions
+
+import (
+	"net"
+)
+
+// RealIPHeader carries the agent address checked against the server trusted
+// subnet.
+const RealIPHeader = "X-Real-IP"
+
+// OutboundIP returns the local address of the interface used to reach
+// address. No packets are sent.
+func OutboundIP(address string) (net.IP, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
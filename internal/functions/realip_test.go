@@ -0,0 +1,16 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboundIP(t *testing.T) {
+	ip, err := OutboundIP("127.0.0.1:8080")
+	assert.NoError(t, err)
+	assert.True(t, ip.IsLoopback())
+
+	_, err = OutboundIP("no-port")
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package functions
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// PushFunc delivers one report to the server.
+type PushFunc func(ctx context.Context, m types.Metrics) error
+
+// Sender delivers reports with a fixed number of workers fed by a bounded
+// queue. It is also a collector of its own queue metrics.
+type Sender struct {
+	push     PushFunc
+	queue    chan types.Metrics
+	workers  int
+	interval time.Duration
+	wg       sync.WaitGroup
+	dropped  int64
+	failed   int64
+}
+
+// NewSender creates a sender with workers concurrent pushes and room for
+// queueSize reports waiting to be sent.
+func NewSender(push PushFunc, workers int, queueSize int, interval time.Duration) *Sender {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &Sender{
+		push:     push,
+		queue:    make(chan types.Metrics, queueSize),
+		workers:  workers,
+		interval: interval,
+	}
+}
+
+// Start runs the workers. ctx bounds every push.
+func (s *Sender) Start(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for m := range s.queue {
+				if err := s.push(ctx, m); err != nil {
+					atomic.AddInt64(&s.failed, 1)
+					log.Println(err)
+				}
+			}
+		}()
+	}
+}
+
+// Send queues a report without blocking and reports whether it was queued.
+// When the queue is full the report is dropped and counted.
+func (s *Sender) Send(m types.Metrics) bool {
+	select {
+	case s.queue <- m:
+		return true
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		log.Printf("sender queue is full (%d reports), dropping report", cap(s.queue))
+		return false
+	}
+}
+
+// SendWait queues a report, waiting for room until ctx is done.
+func (s *Sender) SendWait(ctx context.Context, m types.Metrics) error {
+	select {
+	case s.queue <- m:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&s.dropped, 1)
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting reports and waits until workers have sent the queued
+// ones. No Send may be called after Close.
+func (s *Sender) Close() {
+	close(s.queue)
+	s.wg.Wait()
+}
+
+func (s *Sender) Name() string {
+	return "sender"
+}
+
+func (s *Sender) Interval() time.Duration {
+	return s.interval
+}
+
+// Collect reports queue occupancy and the number of dropped and failed
+// reports since the previous call.
+func (s *Sender) Collect(ctx context.Context) ([]types.Metric, error) {
+	length := float64(len(s.queue))
+	capacity := float64(cap(s.queue))
+	workers := float64(s.workers)
+	dropped := atomic.SwapInt64(&s.dropped, 0)
+	failed := atomic.SwapInt64(&s.failed, 0)
+	return []types.Metric{
+		{ID: "SenderQueueLength", MType: "gauge", Value: &length},
+		{ID: "SenderQueueCapacity", MType: "gauge", Value: &capacity},
+		{ID: "SenderWorkers", MType: "gauge", Value: &workers},
+		{ID: "SenderDroppedReports", MType: "counter", Delta: &dropped},
+		{ID: "SenderFailedReports", MType: "counter", Delta: &failed},
+	}, nil
+}
@@ -0,0 +1,94 @@
+package functions
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestSenderRateLimit(t *testing.T) {
+	const workers = 2
+	var running, peak int64
+	var sent sync.WaitGroup
+	release := make(chan struct{})
+	push := func(ctx context.Context, m types.Metrics) error {
+		defer sent.Done()
+		current := atomic.AddInt64(&running, 1)
+		for {
+			max := atomic.LoadInt64(&peak)
+			if current <= max || atomic.CompareAndSwapInt64(&peak, max, current) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt64(&running, -1)
+		return nil
+	}
+
+	sender := NewSender(push, workers, 10, time.Second)
+	sender.Start(context.Background())
+	for i := 0; i < 6; i++ {
+		sent.Add(1)
+		require.True(t, sender.Send(types.Metrics{}))
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&running) == workers
+	}, time.Second, time.Millisecond)
+	close(release)
+	sent.Wait()
+	sender.Close()
+	assert.Equal(t, int64(workers), atomic.LoadInt64(&peak))
+}
+
+func TestSenderBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var pushed int64
+	push := func(ctx context.Context, m types.Metrics) error {
+		<-release
+		atomic.AddInt64(&pushed, 1)
+		return nil
+	}
+
+	sender := NewSender(push, 1, 2, time.Second)
+	sender.Start(context.Background())
+	require.True(t, sender.Send(types.Metrics{}))
+	// wait until the worker takes the first report off the queue
+	assert.Eventually(t, func() bool {
+		return len(sender.queue) == 0
+	}, time.Second, time.Millisecond)
+	require.True(t, sender.Send(types.Metrics{}))
+	require.True(t, sender.Send(types.Metrics{}))
+	assert.False(t, sender.Send(types.Metrics{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sender.SendWait(ctx, types.Metrics{}), context.DeadlineExceeded)
+
+	metrics, err := sender.Collect(context.Background())
+	require.NoError(t, err)
+	values := make(map[string]types.Metric)
+	for _, metric := range metrics {
+		values[metric.ID] = metric
+	}
+	assert.Equal(t, 2.0, *values["SenderQueueLength"].Value)
+	assert.Equal(t, 2.0, *values["SenderQueueCapacity"].Value)
+	assert.Equal(t, int64(2), *values["SenderDroppedReports"].Delta)
+
+	metrics, err = sender.Collect(context.Background())
+	require.NoError(t, err)
+	for _, metric := range metrics {
+		if metric.ID == "SenderDroppedReports" {
+			assert.Equal(t, int64(0), *metric.Delta)
+		}
+	}
+
+	close(release)
+	sender.Close()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&pushed))
+}
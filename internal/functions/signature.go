@@ -0,0 +1,55 @@
+package functions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+)
+
+// CreateRequestSignature signs a whole request: method, path, unix timestamp,
+// nonce and uncompressed body.
+func CreateRequestSignature(key string, method string, path string, timestamp string, nonce string, body []byte) string {
+	msg := fmt.Sprintf("%s\n%s\n%s\n%s\n%s", method, path, timestamp, nonce, body)
+	return CreateSignedHash(msg, []byte(key))
+}
+
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// signRequest adds request signature headers to every attempt of a request,
+// so retries get a fresh timestamp and nonce. The body must be set as []byte.
+func signRequest(key string) resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		body, _ := r.Body.([]byte)
+		path := r.URL
+		if parsed, err := url.Parse(r.URL); err == nil {
+			path = parsed.Path
+		}
+		nonce, err := newNonce()
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		r.SetHeader(SignatureTimestampHeader, timestamp)
+		r.SetHeader(SignatureNonceHeader, nonce)
+		r.SetHeader(SignatureHeader, CreateRequestSignature(key, r.Method, path, timestamp, nonce, body))
+		return nil
+	}
+}
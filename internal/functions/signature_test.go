@@ -0,0 +1,41 @@
+package functions
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignRequest(t *testing.T) {
+	const key = "secret"
+	var nonces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		timestamp := r.Header.Get(SignatureTimestampHeader)
+		nonce := r.Header.Get(SignatureNonceHeader)
+		expected := CreateRequestSignature(key, r.Method, r.URL.Path, timestamp, nonce, body)
+		assert.Equal(t, expected, r.Header.Get(SignatureHeader))
+		nonces = append(nonces, nonce)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := resty.New().
+		SetBaseURL(server.URL).
+		SetRetryCount(1).
+		AddRetryCondition(func(r *resty.Response, err error) bool {
+			return r.StatusCode() == http.StatusServiceUnavailable
+		})
+	client.OnBeforeRequest(signRequest(key))
+	_, err := client.R().SetBody([]byte(`[{"id":"PollCount"}]`)).Post("/updates")
+	require.NoError(t, err)
+
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
@@ -0,0 +1,83 @@
+package functions
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// NewServerTLSConfig builds TLS settings for the server, or returns nil when
+// no certificate is configured. With a client CA, presented client
+// certificates are verified against it; middlewares.RequireClientCert decides
+// which routes need one.
+func NewServerTLSConfig(cfg *types.ServerConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, fmt.Errorf("client CA requires server certificate and key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSClientCA != "" {
+		tlsConfig.ClientCAs, err = loadCertPool(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// NewAgentTLSConfig builds TLS settings for pushing over https. Without a CA
+// bundle the system roots are used.
+func NewAgentTLSConfig(cfg *types.AgentConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCA != "" {
+		pool, err := loadCertPool(cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// ServerURL returns the base URL the agent pushes metrics to.
+func ServerURL(cfg *types.AgentConfig) (string, error) {
+	switch cfg.Scheme {
+	case "", "http":
+		return "http://" + cfg.Address, nil
+	case "https":
+		return "https://" + cfg.Address, nil
+	}
+	return "", fmt.Errorf("unsupported scheme %q", cfg.Scheme)
+}
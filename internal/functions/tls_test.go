@@ -0,0 +1,47 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		scheme  string
+		want    string
+		wantErr bool
+	}{
+		{name: "should default to http", scheme: "", want: "http://localhost:8080"},
+		{name: "should use https", scheme: "https", want: "https://localhost:8080"},
+		{name: "should reject unknown scheme", scheme: "ftp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := ServerURL(&types.AgentConfig{Address: "localhost:8080", Scheme: tt.scheme})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, url)
+		})
+	}
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	tlsConfig, err := NewServerTLSConfig(&types.ServerConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	_, err = NewServerTLSConfig(&types.ServerConfig{TLSClientCA: "ca.crt"})
+	assert.Error(t, err)
+
+	_, err = NewServerTLSConfig(&types.ServerConfig{TLSCert: "missing.crt", TLSKey: "missing.key"})
+	assert.Error(t, err)
+
+	_, err = NewAgentTLSConfig(&types.AgentConfig{Scheme: "https", TLSCA: "missing.crt"})
+	assert.Error(t, err)
+}
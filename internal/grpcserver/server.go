@@ -0,0 +1,200 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/yurchenkosv/metric-service/internal/functions"
+	pb "github.com/yurchenkosv/metric-service/internal/proto"
+	"github.com/yurchenkosv/metric-service/internal/storage"
+	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsServer implements the Metrics gRPC service on top of the same
+// storage as the HTTP API.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	cfg    *types.ServerConfig
+	store  storage.Repository
+	subnet *net.IPNet
+}
+
+func NewMetricsServer(cfg *types.ServerConfig, store storage.Repository) *MetricsServer {
+	server := &MetricsServer{cfg: cfg, store: store}
+	if cfg.TrustedSubnet != "" {
+		var err error
+		_, server.subnet, err = net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return server
+}
+
+// NewServer returns a gRPC server with the Metrics service registered. When
+// tlsConfig is nil the server accepts plaintext connections.
+func NewServer(cfg *types.ServerConfig, store storage.Repository, tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(server, NewMetricsServer(cfg, store))
+	return server
+}
+
+// checkClientCert mirrors middlewares.RequireClientCert for update calls.
+func (s *MetricsServer) checkClientCert(ctx context.Context) error {
+	if s.cfg.TLSClientCA == "" {
+		return nil
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "client certificate required")
+}
+
+// checkTrustedSubnet mirrors middlewares.CheckTrustedSubnet, reading the
+// agent address from x-real-ip metadata.
+func (s *MetricsServer) checkTrustedSubnet(ctx context.Context) error {
+	if s.cfg.TrustedSubnet == "" {
+		return nil
+	}
+	if s.subnet == nil {
+		return status.Error(codes.Internal, "trusted subnet is misconfigured")
+	}
+	var realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-real-ip"); len(values) > 0 {
+			realIP = values[0]
+		}
+	}
+	ip := net.ParseIP(realIP)
+	if ip == nil || !s.subnet.Contains(ip) {
+		log.Printf("rejecting gRPC update: X-Real-IP %q is outside trusted subnet %s", realIP, s.subnet)
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return nil
+}
+
+func checkMetric(metric types.Metric) error {
+	switch metric.MType {
+	case "counter", "gauge", "histogram", "summary":
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown metric type %q", metric.MType)
+	}
+	for name := range metric.Labels {
+		if !types.ValidLabelName(name) {
+			return status.Errorf(codes.InvalidArgument, "invalid label name %q", name)
+		}
+	}
+	if err := functions.ValidateMetric(metric); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func (s *MetricsServer) insertMetrics(ctx context.Context, batch []*pb.Metric) error {
+	if err := s.checkTrustedSubnet(ctx); err != nil {
+		return err
+	}
+	if err := s.checkClientCert(ctx); err != nil {
+		return err
+	}
+	metrics := make([]types.Metric, 0, len(batch))
+	var invalid []string
+	for _, m := range batch {
+		metric := m.ToMetric()
+		if err := checkMetric(metric); err != nil {
+			return err
+		}
+		if s.cfg.Key != "" {
+			hash := functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(s.cfg.Key))
+			if !hmac.Equal([]byte(hash), []byte(metric.Hash)) {
+				invalid = append(invalid, metric.ID)
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	if len(invalid) > 0 {
+		log.Printf("rejecting gRPC update: invalid hash for metrics %s", strings.Join(invalid, ", "))
+		return status.Error(codes.InvalidArgument, "invalid hash for metrics: "+strings.Join(invalid, ", "))
+	}
+
+	if err := s.store.InsertMetrics(ctx, metrics); err != nil {
+		log.Println(err)
+		var batchErr *storage.BatchError
+		if errors.As(err, &batchErr) {
+			return status.Errorf(codes.Internal, "no metrics stored: cannot store %s", types.SeriesKey(batchErr.ID, batchErr.Labels))
+		}
+		return status.Error(codes.Internal, "cannot store metrics")
+	}
+	if s.cfg.StoreInterval == 0 {
+		functions.FlushMetricsToDisk(ctx, s.cfg, s.store)
+	}
+	return nil
+}
+
+// UpdateMetrics stores a batch of metrics; the whole batch is rejected if
+// any metric is invalid.
+func (s *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if err := s.insertMetrics(ctx, req.GetMetrics()); err != nil {
+		return nil, err
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+// PushMetrics stores batches as they arrive and reports how many metrics
+// were accepted once the client closes the stream.
+func (s *MetricsServer) PushMetrics(stream pb.Metrics_PushMetricsServer) error {
+	var accepted uint64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.PushMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+		if err = s.insertMetrics(stream.Context(), req.GetMetrics()); err != nil {
+			return err
+		}
+		accepted += uint64(len(req.GetMetrics()))
+	}
+}
+
+// GetMetric returns the series selected by type, name and labels.
+func (s *MetricsServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	metrics, err := s.store.FindMetrics(ctx, req.GetType(), req.GetId(), req.GetLabels())
+	if err != nil {
+		log.Println(err)
+		return nil, status.Error(codes.Internal, "cannot read metrics")
+	}
+	metric, err := storage.SelectSeries(metrics, req.GetLabels())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "no metrics found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metric.Hash = ""
+	if s.cfg.Key != "" {
+		metric.Hash = functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(s.cfg.Key))
+	}
+	return &pb.GetMetricResponse{Metric: pb.FromMetric(metric)}, nil
+}
@@ -0,0 +1,157 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/collector"
+	"github.com/yurchenkosv/metric-service/internal/functions"
+	pb "github.com/yurchenkosv/metric-service/internal/proto"
+	"github.com/yurchenkosv/metric-service/internal/storage"
+	"github.com/yurchenkosv/metric-service/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, cfg *types.ServerConfig, store storage.Repository) pb.MetricsClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer(cfg, store, nil)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func TestMetricsServer(t *testing.T) {
+	const key = "secret"
+	cfg := &types.ServerConfig{StoreInterval: 300 * time.Second, Key: key}
+	store := storage.NewMapStorage()
+	client := newTestClient(t, cfg, store)
+	ctx := context.Background()
+
+	signed := func(metric types.Metric) *pb.Metric {
+		metric.Hash = functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(key))
+		return pb.FromMetric(metric)
+	}
+	delta := int64(3)
+	value := 1.5
+	counter := types.Metric{ID: "Requests", MType: "counter", Delta: &delta}
+	gauge := types.Metric{ID: "Alloc", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}}
+
+	tests := []struct {
+		name    string
+		metrics []*pb.Metric
+		code    codes.Code
+	}{
+		{
+			name:    "should store signed batch",
+			metrics: []*pb.Metric{signed(counter), signed(gauge)},
+			code:    codes.OK,
+		},
+		{
+			name:    "should reject batch with invalid hash",
+			metrics: []*pb.Metric{signed(counter), pb.FromMetric(counter)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "should reject unknown type",
+			metrics: []*pb.Metric{signed(types.Metric{ID: "X", MType: "unknown"})},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "should reject metric without value",
+			metrics: []*pb.Metric{signed(types.Metric{ID: "X", MType: "gauge"})},
+			code:    codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: tt.metrics})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	stream, err := client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{signed(counter)}}))
+	require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{signed(counter), signed(gauge)}}))
+	pushed, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), pushed.GetAccepted())
+
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Requests", Type: "counter"})
+	require.NoError(t, err)
+	got := resp.GetMetric().ToMetric()
+	assert.Equal(t, int64(9), *got.Delta)
+	assert.Equal(t, functions.CreateSignedHash(functions.CreateHashMessage(got), []byte(key)), got.Hash)
+
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: "gauge", Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, resp.GetMetric().GetValue())
+	assert.Equal(t, map[string]string{"host": "a"}, resp.GetMetric().GetLabels())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Unknown", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPushMemMetricsGRPC(t *testing.T) {
+	store := storage.NewMapStorage()
+	client := newTestClient(t, &types.ServerConfig{StoreInterval: 300 * time.Second}, store)
+
+	collected, err := collector.NewRuntimeCollector(0).Collect(context.Background())
+	require.NoError(t, err)
+	metrics := functions.PrepareReport(collected, 1, &types.AgentConfig{})
+	require.NoError(t, functions.PushMemMetricsGRPC(context.Background(), client, metrics, &types.AgentConfig{Address: "127.0.0.1:3200"}))
+
+	pollCount, err := store.GetCounterByKey(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(1), pollCount)
+	stored, err := store.FindMetrics(context.Background(), "histogram", "GCPauseNs", nil)
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+}
+
+func TestMetricsServerTrustedSubnet(t *testing.T) {
+	cfg := &types.ServerConfig{StoreInterval: 300 * time.Second, TrustedSubnet: "127.0.0.0/8"}
+	client := newTestClient(t, cfg, storage.NewMapStorage())
+	delta := int64(1)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{pb.FromMetric(types.Metric{ID: "Requests", MType: "counter", Delta: &delta})}}
+
+	tests := []struct {
+		name   string
+		realIP string
+		code   codes.Code
+	}{
+		{name: "should accept trusted address", realIP: "127.0.0.1", code: codes.OK},
+		{name: "should reject other address", realIP: "10.0.0.1", code: codes.PermissionDenied},
+		{name: "should reject missing address", code: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", tt.realIP)
+			}
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	require.NoError(t, functions.PushMemMetricsGRPC(context.Background(), client, functions.PrepareReport(nil, 1, &types.AgentConfig{}), &types.AgentConfig{Address: "127.0.0.1:3200"}))
+}
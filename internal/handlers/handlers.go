@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v4"
 	"github.com/yurchenkosv/metric-service/internal/functions"
 	"github.com/yurchenkosv/metric-service/internal/storage"
 	"github.com/yurchenkosv/metric-service/internal/types"
@@ -13,22 +14,75 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"sync"
+	"time"
 )
 
-var mutex sync.Mutex
+func checkMetricType(metricType string, w http.ResponseWriter) bool {
+	switch metricType {
+	case "counter", "gauge", "histogram", "summary":
+		return true
+	}
+	w.WriteHeader(http.StatusNotImplemented)
+	return false
+}
 
-func checkMetricType(metricType string, w http.ResponseWriter) {
-	if metricType != "counter" && metricType != "gauge" {
-		w.WriteHeader(http.StatusNotImplemented)
-		return
+func isDistribution(metricType string) bool {
+	return metricType == "histogram" || metricType == "summary"
+}
+
+func checkMetric(metric types.Metric, w http.ResponseWriter) bool {
+	if err := functions.ValidateMetric(metric); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return false
 	}
+	return true
 }
 
 func checkForError(err error) bool {
 	return err != nil
 }
 
+func writeStorageError(err error, w http.ResponseWriter) {
+	log.Println(err)
+	w.WriteHeader(http.StatusInternalServerError)
+	var batchErr *storage.BatchError
+	if errors.As(err, &batchErr) {
+		fmt.Fprintf(w, "no metrics stored: cannot store %s", types.SeriesKey(batchErr.ID, batchErr.Labels))
+	}
+}
+
+func checkLabels(labels map[string]string, w http.ResponseWriter) bool {
+	for name := range labels {
+		if !types.ValidLabelName(name) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid label name %q", name)
+			return false
+		}
+	}
+	return true
+}
+
+func findSeries(ctx context.Context, repo storage.Repository, mType string, name string, matchers map[string]string, w http.ResponseWriter) (types.Metric, bool) {
+	metrics, err := repo.FindMetrics(ctx, mType, name, matchers)
+	if checkForError(err) {
+		writeStorageError(err, w)
+		return types.Metric{}, false
+	}
+	metric, err := storage.SelectSeries(metrics, matchers)
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no metrics found"))
+		return metric, false
+	}
+	if errors.Is(err, storage.ErrAmbiguousSeries) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return metric, false
+	}
+	return metric, true
+}
+
 func HandleUpdateMetricJSON(writer http.ResponseWriter, request *http.Request) {
 	var metrics types.Metric
 	ctx := request.Context()
@@ -38,24 +92,28 @@ func HandleUpdateMetricJSON(writer http.ResponseWriter, request *http.Request) {
 	body, err := io.ReadAll(request.Body)
 	if checkForError(err) {
 		writer.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	err = json.Unmarshal(body, &metrics)
 	if checkForError(err) {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	metricType := metrics.MType
-	checkMetricType(metricType, writer)
-	mutex.Lock()
-	defer mutex.Unlock()
-	if metricType == "counter" {
-		counter := types.Counter(*metrics.Delta)
-		mapStorage.AddCounter(metrics.ID, counter)
+	if !checkMetricType(metricType, writer) {
+		return
 	}
-	if metricType == "gauge" {
-		gauge := types.Gauge(*metrics.Value)
-		mapStorage.AddGauge(metrics.ID, gauge)
+	if !checkLabels(metrics.Labels, writer) {
+		return
+	}
+	if !checkMetric(metrics, writer) {
+		return
+	}
+	err = mapStorage.InsertMetrics(ctx, []types.Metric{metrics})
+	if checkForError(err) {
+		writeStorageError(err, writer)
 	}
 }
 
@@ -70,13 +128,31 @@ func HandleUpdatesJSON(writer http.ResponseWriter, request *http.Request) {
 	storage := *store
 
 	data, err := io.ReadAll(request.Body)
-	checkForError(err)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	err = json.Unmarshal(data, &metrics)
-	checkForError(err)
-	storage.InsertMetrics(metrics)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for i := range metrics {
+		if !checkMetricType(metrics[i].MType, writer) {
+			return
+		}
+		if !checkLabels(metrics[i].Labels, writer) || !checkMetric(metrics[i], writer) {
+			return
+		}
+	}
+	err = storage.InsertMetrics(ctx, metrics)
+	if checkForError(err) {
+		writeStorageError(err, writer)
+	}
 }
 
 func HandleUpdateMetric(writer http.ResponseWriter, request *http.Request) {
+	var err error
 
 	ctx := request.Context()
 	store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
@@ -86,22 +162,44 @@ func HandleUpdateMetric(writer http.ResponseWriter, request *http.Request) {
 	metricName := chi.URLParam(request, "metricName")
 	metricValue := chi.URLParam(request, "metricValue")
 
-	checkMetricType(metricType, writer)
-	mutex.Lock()
-	defer mutex.Unlock()
+	if !checkMetricType(metricType, writer) {
+		return
+	}
+	if isDistribution(metricType) {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(metricType + " can only be updated with JSON API"))
+		return
+	}
+	labels := functions.LabelsFromQuery(request.URL.Query())
+	if !checkLabels(labels, writer) {
+		return
+	}
+	metric := types.Metric{ID: metricName, MType: metricType, Labels: labels}
 	if metricType == "counter" {
-		val, err := strconv.ParseInt(metricValue, 10, 64)
-		if err != nil {
+		val, parseErr := strconv.ParseInt(metricValue, 10, 64)
+		if parseErr != nil {
 			writer.WriteHeader(http.StatusBadRequest)
+			return
 		}
-		mapStorage.AddCounter(metricName, types.Counter(val))
+		metric.Delta = &val
 	}
 	if metricType == "gauge" {
-		val, err := strconv.ParseFloat(metricValue, 64)
-		if err != nil {
+		val, parseErr := strconv.ParseFloat(metricValue, 64)
+		if parseErr != nil {
 			writer.WriteHeader(http.StatusBadRequest)
+			return
 		}
-		mapStorage.AddGauge(metricName, types.Gauge(val))
+		metric.Value = &val
+	}
+	if len(labels) > 0 {
+		err = mapStorage.InsertMetrics(ctx, []types.Metric{metric})
+	} else if metricType == "counter" {
+		err = mapStorage.AddCounter(ctx, metricName, types.Counter(*metric.Delta))
+	} else {
+		err = mapStorage.AddGauge(ctx, metricName, types.Gauge(*metric.Value))
+	}
+	if checkForError(err) {
+		writeStorageError(err, writer)
 	}
 }
 
@@ -112,12 +210,41 @@ func HandleGetMetric(writer http.ResponseWriter, request *http.Request) {
 
 	metricType := chi.URLParam(request, "metricType")
 	metricName := chi.URLParam(request, "metricName")
-	checkMetricType(metricType, writer)
+	if !checkMetricType(metricType, writer) {
+		return
+	}
 
-	val, err := mapStorage.GetMetricByKey(metricName)
-	if err != nil {
+	matchers := functions.LabelsFromQuery(request.URL.Query())
+	if len(matchers) > 0 || isDistribution(metricType) {
+		metric, ok := findSeries(ctx, mapStorage, metricType, metricName, matchers, writer)
+		if !ok {
+			return
+		}
+		if isDistribution(metricType) {
+			data, err := json.Marshal(metric)
+			if checkForError(err) {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write(data)
+		} else if metric.Delta != nil {
+			writer.Write([]byte(fmt.Sprintf("%v", *metric.Delta)))
+		} else if metric.Value != nil {
+			writer.Write([]byte(fmt.Sprintf("%.3f", *metric.Value)))
+		}
+		return
+	}
+
+	val, err := mapStorage.GetMetric(ctx, metricType, metricName)
+	if errors.Is(err, storage.ErrNotFound) {
 		writer.WriteHeader(http.StatusNotFound)
 		writer.Write([]byte("no metrics found"))
+		return
+	}
+	if checkForError(err) {
+		writeStorageError(err, writer)
+		return
 	}
 	writer.Write([]byte(val))
 }
@@ -127,14 +254,140 @@ func HandleGetAllMetrics(writer http.ResponseWriter, request *http.Request) {
 	store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
 	mapStorage := *store
 
-	val := mapStorage.GetAllMetrics()
+	val, err := mapStorage.GetAllMetrics(ctx)
+	if checkForError(err) {
+		writeStorageError(err, writer)
+		return
+	}
 	writer.Header().Set("Content-Type", "text/html")
 	writer.Write([]byte(val))
 }
 
+func HandlePrometheusMetrics(writer http.ResponseWriter, request *http.Request) {
+	ctx := request.Context()
+	config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
+	store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
+	mapStorage := *store
+
+	labels, err := functions.ParseLabels(config.PrometheusLabels)
+	if checkForError(err) {
+		log.Println(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	metrics, err := mapStorage.AsMetrics(ctx)
+	if checkForError(err) {
+		writeStorageError(err, writer)
+		return
+	}
+	writer.Header().Set("Content-Type", functions.PrometheusContentType)
+	writer.Write([]byte(functions.FormatPrometheus(metrics, labels)))
+}
+
+const maxQueryRangePoints = 11000
+
+var queryRangeParams = map[string]bool{"id": true, "type": true, "start": true, "end": true, "step": true}
+
+// HandleQueryRange returns points of a series between start and end spaced by
+// step. Query parameters other than id, type, start, end and step select the
+// series labels.
+func HandleQueryRange(writer http.ResponseWriter, request *http.Request) {
+	var err error
+	ctx := request.Context()
+	store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
+	mapStorage := *store
+
+	query := request.URL.Query()
+	metricType := query.Get("type")
+	metricName := query.Get("id")
+	if metricName == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("id is required"))
+		return
+	}
+	if !checkMetricType(metricType, writer) {
+		return
+	}
+	if metricType != "counter" && metricType != "gauge" {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("query_range supports counter and gauge only"))
+		return
+	}
+
+	end := time.Now()
+	if query.Get("end") != "" {
+		end, err = functions.ParseTimestamp(query.Get("end"))
+		if checkForError(err) {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("invalid end: " + err.Error()))
+			return
+		}
+	}
+	start := end.Add(-time.Hour)
+	if query.Get("start") != "" {
+		start, err = functions.ParseTimestamp(query.Get("start"))
+		if checkForError(err) {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("invalid start: " + err.Error()))
+			return
+		}
+	}
+	step := time.Minute
+	if query.Get("step") != "" {
+		step, err = functions.ParseStep(query.Get("step"))
+		if checkForError(err) {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("invalid step: " + err.Error()))
+			return
+		}
+	}
+	if step <= 0 || end.Before(start) || end.Sub(start)/step >= maxQueryRangePoints {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("start, end and step must describe at most 11000 points"))
+		return
+	}
+
+	labels := make(map[string]string)
+	for k := range query {
+		if !queryRangeParams[k] {
+			labels[k] = query.Get(k)
+		}
+	}
+	if !checkLabels(labels, writer) {
+		return
+	}
+
+	samples, err := mapStorage.GetSamples(ctx, metricType, metricName, labels, start.Add(-step), end)
+	if errors.Is(err, storage.ErrNotFound) {
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write([]byte("no metrics found"))
+		return
+	}
+	if checkForError(err) {
+		writeStorageError(err, writer)
+		return
+	}
+
+	result := types.SeriesRange{
+		ID:     metricName,
+		MType:  metricType,
+		Points: functions.AlignSamples(samples, start, end, step),
+	}
+	if len(labels) > 0 {
+		result.Labels = labels
+	}
+	data, err := json.Marshal(result)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(data)
+}
+
 func HandleGetMetricJSON(writer http.ResponseWriter, request *http.Request) {
 	var metric types.Metric
-	var msg string
 
 	ctx := request.Context()
 	config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
@@ -148,56 +401,79 @@ func HandleGetMetricJSON(writer http.ResponseWriter, request *http.Request) {
 	mapStorage := *store
 
 	data, err := io.ReadAll(request.Body)
-	checkForError(err)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	err = json.Unmarshal(data, &metric)
-	checkForError(err)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	if metric.MType == "counter" {
-		val, err := mapStorage.GetCounterByKey(metric.ID)
-		if err != nil {
+	if len(metric.Labels) > 0 || isDistribution(metric.MType) {
+		found, ok := findSeries(ctx, mapStorage, metric.MType, metric.ID, metric.Labels, writer)
+		if !ok {
+			return
+		}
+		metric = found
+	} else if metric.MType == "counter" {
+		val, err := mapStorage.GetCounterByKey(ctx, metric.ID)
+		if errors.Is(err, storage.ErrNotFound) {
 			writer.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if checkForError(err) {
+			writeStorageError(err, writer)
+			return
+		}
 		counter := int64(val)
-		msg = fmt.Sprintf("%s:counter:%d", metric.ID, counter)
 		metric.Delta = &counter
-	}
-	if metric.MType == "gauge" {
-		val, err := mapStorage.GetGaugeByKey(metric.ID)
-		if err != nil {
+	} else if metric.MType == "gauge" {
+		val, err := mapStorage.GetGaugeByKey(ctx, metric.ID)
+		if errors.Is(err, storage.ErrNotFound) {
 			writer.WriteHeader(http.StatusNotFound)
 			log.Println(err)
 			return
 		}
+		if checkForError(err) {
+			writeStorageError(err, writer)
+			return
+		}
 		gauge := float64(val)
-		msg = fmt.Sprintf("%s:gauge:%f", metric.ID, gauge)
 		metric.Value = &gauge
 	}
 
 	if config.Key != "" {
+		msg := functions.CreateHashMessage(metric)
 		metric.Hash = functions.CreateSignedHash(msg, []byte(config.Key))
 	} else {
 		metric.Hash = ""
 	}
 
 	data, err = json.Marshal(metric)
-	checkForError(err)
+	if checkForError(err) {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	writer.Header().Add("Content-Type", "application/json")
 	writer.Write(data)
 }
 
 func HealthChecks(writer http.ResponseWriter, request *http.Request) {
 	ctx := request.Context()
-	config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
-	if config.DBDsn == "" {
+	store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
+	pinger, ok := (*store).(interface {
+		Ping(context.Context) error
+	})
+	if !ok {
 		writer.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
 
-	conn, err := pgx.Connect(ctx, config.DBDsn)
+	err := pinger.Ping(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
 		writer.WriteHeader(http.StatusInternalServerError)
 	}
-	defer conn.Close(ctx)
 }
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/yurchenkosv/metric-service/internal/encryption"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// DecryptBody decrypts request bodies encrypted by the agent with the public
+// part of config.CryptoKey. Plain requests are passed through untouched.
+func DecryptBody(config *types.ServerConfig) func(next http.Handler) http.Handler {
+	var key *rsa.PrivateKey
+	if config.CryptoKey != "" {
+		var err error
+		key, err = encryption.LoadPrivateKey(config.CryptoKey)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			algorithm := r.Header.Get(encryption.Header)
+			if algorithm == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if algorithm != encryption.Algorithm {
+				http.Error(w, "unsupported encryption "+strconv.Quote(algorithm), http.StatusBadRequest)
+				return
+			}
+			if config.CryptoKey == "" {
+				http.Error(w, "encryption is not configured", http.StatusBadRequest)
+				return
+			}
+			if key == nil {
+				http.Error(w, "crypto key is not loaded", http.StatusInternalServerError)
+				return
+			}
+
+			message, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body, err := encryption.Decrypt(key, message)
+			if err != nil {
+				log.Println("cannot decrypt request body:", err)
+				http.Error(w, "cannot decrypt request body", http.StatusBadRequest)
+				return
+			}
+
+			r.Header.Del(encryption.Header)
+			r.ContentLength = int64(len(body))
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"context"
 	"crypto/hmac"
 	"encoding/json"
-	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/yurchenkosv/metric-service/internal/functions"
 	"github.com/yurchenkosv/metric-service/internal/storage"
 	"github.com/yurchenkosv/metric-service/internal/types"
@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -46,7 +47,7 @@ func SaveMetricToFile(next http.Handler) http.Handler {
 		if config.StoreInterval == 0 {
 			store := ctx.Value(types.ContextKey("storage")).(*storage.Repository)
 			mapStorage := *store
-			functions.FlushMetricsToDisk(config, mapStorage)
+			functions.FlushMetricsToDisk(ctx, config, mapStorage)
 		}
 		next.ServeHTTP(w, r)
 	}
@@ -95,35 +96,101 @@ func GzipCompress(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// invalidHashIDs returns IDs of metrics whose hash does not match the key.
+func invalidHashIDs(metrics []types.Metric, key string) []string {
+	var invalid []string
+	for _, metric := range metrics {
+		hash := functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(key))
+		if !hmac.Equal([]byte(hash), []byte(metric.Hash)) {
+			invalid = append(invalid, metric.ID)
+		}
+	}
+	return invalid
+}
+
+func rejectInvalidHashes(w http.ResponseWriter, r *http.Request, invalid []string) {
+	log.Printf("rejecting request to %s: invalid hash for metrics %s", r.URL.Path, strings.Join(invalid, ", "))
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, "invalid hash for metrics: "+strings.Join(invalid, ", "))
+}
+
+// CheckHash verifies hashes of a JSON metric or an array of metrics in the
+// request body when the server has a key configured.
 func CheckHash(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
 
 		if config.Key != "" {
-			var metric types.Metric
-			var msg string
+			var metrics []types.Metric
 			data, err := io.ReadAll(r.Body)
-			r.Body = ioutil.NopCloser(bytes.NewReader(data))
 			if err != nil {
-				log.Fatal(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			err = json.Unmarshal(data, &metric)
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+			trimmed := bytes.TrimSpace(data)
+			if len(trimmed) > 0 && trimmed[0] == '[' {
+				err = json.Unmarshal(trimmed, &metrics)
+			} else {
+				var metric types.Metric
+				err = json.Unmarshal(trimmed, &metric)
+				metrics = []types.Metric{metric}
+			}
 			if err != nil {
-				log.Fatal(err)
+				http.Error(w, "malformed metrics: "+err.Error(), http.StatusBadRequest)
 				return
 			}
-			if metric.MType == "counter" {
-				counter := *metric.Delta
-				msg = fmt.Sprintf("%s:counter:%d", metric.ID, counter)
-			} else if metric.MType == "gauge" {
-				gauge := *metric.Value
-				msg = fmt.Sprintf("%s:gauge:%f", metric.ID, gauge)
+
+			if invalid := invalidHashIDs(metrics, config.Key); len(invalid) > 0 {
+				rejectInvalidHashes(w, r, invalid)
+				return
 			}
-			hash := functions.CreateSignedHash(msg, []byte(config.Key))
-			if !hmac.Equal([]byte(hash), []byte(metric.Hash)) {
-				w.WriteHeader(http.StatusBadRequest)
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// CheckURLHash verifies the Hash header of /update/{metricType}/{metricName}/{metricValue}
+// requests when the server has a key configured. Requests the handler would
+// reject anyway are passed through untouched.
+func CheckURLHash(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
+
+		if config.Key != "" {
+			metric := types.Metric{
+				ID:     chi.URLParam(r, "metricName"),
+				MType:  chi.URLParam(r, "metricType"),
+				Hash:   r.Header.Get("Hash"),
+				Labels: functions.LabelsFromQuery(r.URL.Query()),
+			}
+			metricValue := chi.URLParam(r, "metricValue")
+			switch metric.MType {
+			case "counter":
+				delta, err := strconv.ParseInt(metricValue, 10, 64)
+				if err != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				metric.Delta = &delta
+			case "gauge":
+				value, err := strconv.ParseFloat(metricValue, 64)
+				if err != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				metric.Value = &value
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if invalid := invalidHashIDs([]types.Metric{metric}, config.Key); len(invalid) > 0 {
+				rejectInvalidHashes(w, r, invalid)
 				return
 			}
 		}
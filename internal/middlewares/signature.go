@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/functions"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+const defaultSignatureWindow = 5 * time.Minute
+
+// nonceCache remembers nonces until they expire.
+type nonceCache struct {
+	mutex     sync.Mutex
+	nonces    map[string]time.Time
+	nextPurge time.Time
+}
+
+// remember stores nonce until expiry and reports whether it was unused.
+func (c *nonceCache) remember(nonce string, now time.Time, expiry time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.After(c.nextPurge) {
+		for k, v := range c.nonces {
+			if now.After(v) {
+				delete(c.nonces, k)
+			}
+		}
+		c.nextPurge = now.Add(time.Minute)
+	}
+	if v, ok := c.nonces[nonce]; ok && !now.After(v) {
+		return false
+	}
+	c.nonces[nonce] = expiry
+	return true
+}
+
+func rejectSignature(w http.ResponseWriter, r *http.Request, reason string) {
+	log.Printf("rejecting request to %s from %s: %s", r.URL.Path, r.RemoteAddr, reason)
+	w.WriteHeader(http.StatusUnauthorized)
+	io.WriteString(w, reason)
+}
+
+// CheckRequestSignature verifies whole-request signatures made with the server
+// key. Requests whose timestamp is outside of the signature window or whose
+// nonce was already used within it are rejected. Unsigned requests pass
+// unless the server requires signatures.
+func CheckRequestSignature() func(next http.Handler) http.Handler {
+	cache := &nonceCache{nonces: make(map[string]time.Time)}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			config := ctx.Value(types.ContextKey("config")).(*types.ServerConfig)
+			if config.Key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			signature := r.Header.Get(functions.SignatureHeader)
+			if signature == "" {
+				if config.RequireSignature {
+					rejectSignature(w, r, "request signature is required")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			window := config.SignatureWindow
+			if window <= 0 {
+				window = defaultSignatureWindow
+			}
+			timestamp := r.Header.Get(functions.SignatureTimestampHeader)
+			nonce := r.Header.Get(functions.SignatureNonceHeader)
+			seconds, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil || nonce == "" {
+				rejectSignature(w, r, "malformed request signature")
+				return
+			}
+			now := time.Now()
+			signedAt := time.Unix(seconds, 0)
+			if signedAt.Before(now.Add(-window)) || signedAt.After(now.Add(window)) {
+				rejectSignature(w, r, "stale request signature")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			expected := functions.CreateRequestSignature(config.Key, r.Method, r.URL.Path, timestamp, nonce, body)
+			if !hmac.Equal([]byte(expected), []byte(signature)) {
+				rejectSignature(w, r, "invalid request signature")
+				return
+			}
+			// the nonce can not be replayed while its timestamp is within the window
+			if !cache.remember(nonce, now, signedAt.Add(window)) {
+				rejectSignature(w, r, "request nonce already used")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// CheckTrustedSubnet rejects requests whose X-Real-IP header is missing or
+// outside config.TrustedSubnet. Empty subnet disables the check.
+func CheckTrustedSubnet(config *types.ServerConfig) func(next http.Handler) http.Handler {
+	var subnet *net.IPNet
+	if config.TrustedSubnet != "" {
+		var err error
+		_, subnet, err = net.ParseCIDR(config.TrustedSubnet)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if config.TrustedSubnet == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if subnet == nil {
+				http.Error(w, "trusted subnet is misconfigured", http.StatusInternalServerError)
+				return
+			}
+			realIP := r.Header.Get("X-Real-IP")
+			ip := net.ParseIP(realIP)
+			if ip == nil {
+				log.Printf("rejecting request to %s from %s: missing or malformed X-Real-IP %q", r.URL.Path, r.RemoteAddr, realIP)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			if !subnet.Contains(ip) {
+				log.Printf("rejecting request to %s from %s: X-Real-IP %s is outside trusted subnet %s", r.URL.Path, r.RemoteAddr, ip, subnet)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
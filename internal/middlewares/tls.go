@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// RequireClientCert rejects requests without a verified client certificate
+// when the server is configured with a client CA.
+func RequireClientCert(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		config := r.Context().Value(types.ContextKey("config")).(*types.ServerConfig)
+		if config.TLSClientCA != "" && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
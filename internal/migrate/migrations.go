@@ -2,19 +2,28 @@ package migration
 
 import (
 	"log"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
+// Migrate applies the migrations of the database dialect dbConnection points
+// to: sqlite:// URLs get the SQLite ones, anything else the Postgres ones.
 func Migrate(dbConnection string) {
+	dialect := "postgres"
+	if strings.HasPrefix(dbConnection, "sqlite://") {
+		dialect = "sqlite"
+	}
 	m, err := migrate.New(
-		"file://internal/storage/migrations",
+		"file://internal/storage/migrations/"+dialect,
 		dbConnection)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer m.Close()
 	if err := m.Up(); err != nil {
 		if err != migrate.ErrNoChange {
 			log.Fatal(err)
@@ -0,0 +1,50 @@
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import "github.com/yurchenkosv/metric-service/internal/types"
+
+// FromMetric converts a metric into its protobuf form.
+func FromMetric(metric types.Metric) *Metric {
+	result := &Metric{
+		Id:     metric.ID,
+		Type:   metric.MType,
+		Delta:  metric.Delta,
+		Value:  metric.Value,
+		Hash:   metric.Hash,
+		Sum:    metric.Sum,
+		Count:  metric.Count,
+		Labels: metric.Labels,
+	}
+	for _, bucket := range metric.Buckets {
+		result.Buckets = append(result.Buckets, &Bucket{UpperBound: bucket.UpperBound, Count: bucket.Count})
+	}
+	for _, quantile := range metric.Quantiles {
+		result.Quantiles = append(result.Quantiles, &Quantile{Quantile: quantile.Quantile, Value: quantile.Value})
+	}
+	return result
+}
+
+// ToMetric converts a protobuf metric back into types.Metric.
+func (m *Metric) ToMetric() types.Metric {
+	result := types.Metric{
+		ID:     m.GetId(),
+		MType:  m.GetType(),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.GetHash(),
+		Sum:    m.Sum,
+		Count:  m.Count,
+		Labels: m.GetLabels(),
+	}
+	if len(result.Labels) == 0 {
+		result.Labels = nil
+	}
+	for _, bucket := range m.GetBuckets() {
+		result.Buckets = append(result.Buckets, types.Bucket{UpperBound: bucket.GetUpperBound(), Count: bucket.GetCount()})
+	}
+	for _, quantile := range m.GetQuantiles() {
+		result.Quantiles = append(result.Quantiles, types.Quantile{Quantile: quantile.GetQuantile(), Value: quantile.GetValue()})
+	}
+	return result
+}
@@ -1,11 +1,26 @@
 package routers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/yurchenkosv/metric-service/internal/encryption"
+	"github.com/yurchenkosv/metric-service/internal/functions"
 	"github.com/yurchenkosv/metric-service/internal/storage"
 	"github.com/yurchenkosv/metric-service/internal/types"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -108,6 +123,16 @@ func TestRouter(t *testing.T) {
 				headers:    map[string]string{},
 			},
 		},
+		{
+			name:      "should return 200 when scraping prometheus metrics",
+			urlToCall: "/metrics",
+			method:    http.MethodGet,
+			headers:   map[string]string{},
+			want: want{
+				statusCode: http.StatusOK,
+				headers:    map[string]string{},
+			},
+		},
 		//{
 		//	name:      "should return 400 when no Content-Type header supplied",
 		//	urlToCall: "/update/counter/NewCounterMetric/1",
@@ -146,3 +171,756 @@ func TestRouter(t *testing.T) {
 		})
 	}
 }
+
+type failingStorage struct{}
+
+var errStorageDown = errors.New("storage is down")
+
+func (f failingStorage) AddCounter(context.Context, string, types.Counter) error {
+	return errStorageDown
+}
+
+func (f failingStorage) AddGauge(context.Context, string, types.Gauge) error {
+	return errStorageDown
+}
+
+func (f failingStorage) GetMetric(context.Context, string, string) (string, error) {
+	return "", errStorageDown
+}
+
+func (f failingStorage) GetCounterByKey(context.Context, string) (types.Counter, error) {
+	return 0, errStorageDown
+}
+
+func (f failingStorage) GetGaugeByKey(context.Context, string) (types.Gauge, error) {
+	return 0, errStorageDown
+}
+
+func (f failingStorage) GetAllMetrics(context.Context) (string, error) {
+	return "", errStorageDown
+}
+
+func (f failingStorage) AsMetrics(context.Context) (types.Metrics, error) {
+	return types.Metrics{}, errStorageDown
+}
+
+func (f failingStorage) InsertMetrics(context.Context, []types.Metric) error {
+	return errStorageDown
+}
+
+func (f failingStorage) FindMetrics(context.Context, string, string, map[string]string) ([]types.Metric, error) {
+	return nil, errStorageDown
+}
+
+func (f failingStorage) GetSamples(context.Context, string, string, map[string]string, time.Time, time.Time) ([]types.Sample, error) {
+	return nil, errStorageDown
+}
+
+func TestRouterStorageFailure(t *testing.T) {
+	tests := []struct {
+		urlToCall string
+		name      string
+		method    string
+		headers   map[string]string
+	}{
+		{
+			name:      "should return 500 when storage fails to add gauge",
+			urlToCall: "/update/gauge/NewMetric/0.23",
+			method:    http.MethodPost,
+			headers:   map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:      "should return 500 when storage fails to read metric",
+			urlToCall: "/value/counter/NewCounterMetric",
+			method:    http.MethodGet,
+			headers:   map[string]string{},
+		},
+		{
+			name:      "should return 500 when storage fails to list metrics",
+			urlToCall: "/",
+			method:    http.MethodGet,
+			headers:   map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := types.ServerConfig{
+				Address:       "localhost:8080",
+				StoreInterval: 300 * time.Second,
+				Restore:       false,
+			}
+			var store storage.Repository = failingStorage{}
+			r := NewRouter(&cfg, &store)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			resp, _ := testRequest(t, ts, tt.method, tt.urlToCall, tt.headers)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		})
+	}
+}
+
+func TestRouterSameNameDifferentTypes(t *testing.T) {
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+	}
+	store := storage.NewMapStorage()
+	r := NewRouter(&cfg, &store)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	textHeaders := map[string]string{"Content-Type": "text/plain"}
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/1", textHeaders)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		headers    map[string]string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "should return 200 when updating counter named as gauge",
+			method:     http.MethodPost,
+			path:       "/update/counter/Alloc/2",
+			headers:    textHeaders,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should return 200 when batch adds counter named as gauge",
+			method:     http.MethodPost,
+			path:       "/updates",
+			body:       `[{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"counter","delta":1}]`,
+			headers:    jsonHeaders,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should return 200 when reading counter",
+			method:     http.MethodGet,
+			path:       "/value/counter/Alloc",
+			statusCode: http.StatusOK,
+			want:       "3",
+		},
+		{
+			name:       "should return 200 for counter of batch",
+			method:     http.MethodGet,
+			path:       "/value/counter/PollCount",
+			statusCode: http.StatusOK,
+			want:       "1",
+		},
+		{
+			name:       "should return 200 when reading gauge",
+			method:     http.MethodGet,
+			path:       "/value/gauge/Alloc",
+			statusCode: http.StatusOK,
+			want:       "1.000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequestWithBody(t, ts, tt.method, tt.path, tt.body, tt.headers)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, body)
+			}
+		})
+	}
+}
+
+type rejectingStorage struct {
+	failingStorage
+}
+
+func (r rejectingStorage) InsertMetrics(ctx context.Context, metrics []types.Metric) error {
+	return &storage.BatchError{ID: metrics[len(metrics)-1].ID, Err: errStorageDown}
+}
+
+func TestRouterBatchRejected(t *testing.T) {
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+	}
+	var store storage.Repository = rejectingStorage{}
+	r := NewRouter(&cfg, &store)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	body := `[{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"gauge","value":1}]`
+	resp, respBody := testRequestWithBody(t, ts, http.MethodPost, "/updates", body, map[string]string{"Content-Type": "application/json"})
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "no metrics stored: cannot store Alloc", respBody)
+}
+
+func TestRouterLabels(t *testing.T) {
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+		Restore:       false,
+	}
+	store := storage.NewMapStorage()
+	r := NewRouter(&cfg, &store)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	textHeaders := map[string]string{"Content-Type": "text/plain"}
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/1?host=a", textHeaders)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/2?host=b", textHeaders)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/value/gauge/Alloc?host=b", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2.000", body)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/Alloc?host=c", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/Alloc", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/2?1bad=x", textHeaders)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRouterQueryRange(t *testing.T) {
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+		Restore:       false,
+	}
+	store := storage.NewMapStorage()
+	r := NewRouter(&cfg, &store)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/counter/Requests/3?host=a", nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/counter/Requests/4?host=a", nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/query_range?type=counter&id=Requests&host=a&step=1s", nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result types.SeriesRange
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.NotEmpty(t, result.Points)
+	assert.Equal(t, float64(7), result.Points[len(result.Points)-1].Value)
+	assert.Equal(t, map[string]string{"host": "a"}, result.Labels)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/query_range?type=counter&id=Unknown", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/query_range?type=counter&id=Requests&host=a&step=0", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/query_range?type=histogram&id=Latency", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testRequestWithBody(t *testing.T, ts *httptest.Server, method, path string, body string, headers map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	for headerKey, headerVal := range headers {
+		req.Header.Add(headerKey, headerVal)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	return resp, string(respBody)
+}
+
+func TestRouterHashVerification(t *testing.T) {
+	const key = "secret"
+	sign := func(metric types.Metric) types.Metric {
+		metric.Hash = functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(key))
+		return metric
+	}
+	marshal := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return string(data)
+	}
+	delta := int64(2)
+	value := 1.5
+	goodCounter := sign(types.Metric{ID: "Good", MType: "counter", Delta: &delta})
+	tampered := 2.5
+	badGauge := sign(types.Metric{ID: "Bad", MType: "gauge", Value: &value})
+	badGauge.Value = &tampered
+
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		headers    map[string]string
+		statusCode int
+		respBody   string
+	}{
+		{
+			name:       "should accept signed metric",
+			path:       "/update",
+			body:       marshal(goodCounter),
+			headers:    jsonHeaders,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject tampered metric",
+			path:       "/update",
+			body:       marshal(badGauge),
+			headers:    jsonHeaders,
+			statusCode: http.StatusBadRequest,
+			respBody:   "invalid hash for metrics: Bad",
+		},
+		{
+			name:       "should reject batch naming offending metrics",
+			path:       "/updates",
+			body:       marshal([]types.Metric{goodCounter, badGauge}),
+			headers:    jsonHeaders,
+			statusCode: http.StatusBadRequest,
+			respBody:   "invalid hash for metrics: Bad",
+		},
+		{
+			name:       "should accept signed batch",
+			path:       "/updates",
+			body:       marshal([]types.Metric{goodCounter}),
+			headers:    jsonHeaders,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject malformed body",
+			path:       "/updates",
+			body:       "[{",
+			headers:    jsonHeaders,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "should accept signed url update",
+			path:       "/update/counter/Good/2",
+			headers:    map[string]string{"Hash": goodCounter.Hash},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject unsigned url update",
+			path:       "/update/counter/Good/3",
+			headers:    map[string]string{"Hash": goodCounter.Hash},
+			statusCode: http.StatusBadRequest,
+			respBody:   "invalid hash for metrics: Good",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := types.ServerConfig{
+				Address:       "localhost:8080",
+				StoreInterval: 300 * time.Second,
+				Restore:       false,
+				Key:           key,
+			}
+			store := storage.NewMapStorage()
+			r := NewRouter(&cfg, &store)
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			resp, body := testRequestWithBody(t, ts, http.MethodPost, tt.path, tt.body, tt.headers)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			if tt.respBody != "" {
+				assert.Equal(t, tt.respBody, body)
+			}
+		})
+	}
+}
+
+func TestRouterRequestSignature(t *testing.T) {
+	const key = "secret"
+	cfg := types.ServerConfig{
+		Address:          "localhost:8080",
+		StoreInterval:    300 * time.Second,
+		Restore:          false,
+		Key:              key,
+		RequireSignature: true,
+		SignatureWindow:  time.Minute,
+	}
+	store := storage.NewMapStorage()
+	r := NewRouter(&cfg, &store)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	delta := int64(1)
+	metric := types.Metric{ID: "Requests", MType: "counter", Delta: &delta}
+	metric.Hash = functions.CreateSignedHash(functions.CreateHashMessage(metric), []byte(key))
+	data, err := json.Marshal([]types.Metric{metric})
+	require.NoError(t, err)
+	body := string(data)
+
+	signedHeaders := func(timestamp time.Time, nonce string, body string) map[string]string {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		return map[string]string{
+			"Content-Type":                     "application/json",
+			functions.SignatureTimestampHeader: ts,
+			functions.SignatureNonceHeader:     nonce,
+			functions.SignatureHeader:          functions.CreateRequestSignature(key, http.MethodPost, "/updates", ts, nonce, []byte(body)),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		body       string
+		statusCode int
+	}{
+		{
+			name:       "should accept signed request",
+			headers:    signedHeaders(time.Now(), "nonce-1", body),
+			body:       body,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject replayed nonce",
+			headers:    signedHeaders(time.Now(), "nonce-1", body),
+			body:       body,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "should reject stale timestamp",
+			headers:    signedHeaders(time.Now().Add(-2*time.Minute), "nonce-2", body),
+			body:       body,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "should reject tampered body",
+			headers:    signedHeaders(time.Now(), "nonce-3", body),
+			body:       strings.Replace(body, `"delta":1`, `"delta":100`, 1),
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "should reject unsigned request when signature is required",
+			headers:    map[string]string{"Content-Type": "application/json"},
+			body:       body,
+			statusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequestWithBody(t, ts, http.MethodPost, "/updates", tt.body, tt.headers)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	counter, err := store.GetCounterByKey(context.Background(), "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(1), counter)
+}
+
+func TestRouterEncryption(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+		Restore:       false,
+		CryptoKey:     keyPath,
+	}
+	store := storage.NewMapStorage()
+	ts := httptest.NewServer(NewRouter(&cfg, &store))
+	defer ts.Close()
+
+	plainCfg := types.ServerConfig{Address: "localhost:8080", StoreInterval: 300 * time.Second}
+	plainStore := storage.NewMapStorage()
+	plainTS := httptest.NewServer(NewRouter(&plainCfg, &plainStore))
+	defer plainTS.Close()
+
+	encrypt := func(body string) string {
+		message, err := encryption.Encrypt(&privateKey.PublicKey, []byte(body))
+		require.NoError(t, err)
+		return string(message)
+	}
+	body := `[{"id":"Requests","type":"counter","delta":1}]`
+	encryptedHeaders := map[string]string{
+		"Content-Type":    "application/json",
+		encryption.Header: encryption.Algorithm,
+	}
+	corrupted := []byte(encrypt(body))
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		server     *httptest.Server
+		headers    map[string]string
+		body       string
+		statusCode int
+	}{
+		{
+			name:       "should accept encrypted request",
+			server:     ts,
+			headers:    encryptedHeaders,
+			body:       encrypt(body),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should accept plain request",
+			server:     ts,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			body:       body,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject corrupted ciphertext",
+			server:     ts,
+			headers:    encryptedHeaders,
+			body:       string(corrupted),
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "should reject unknown algorithm",
+			server: ts,
+			headers: map[string]string{
+				"Content-Type":    "application/json",
+				encryption.Header: "rot13",
+			},
+			body:       encrypt(body),
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "should reject encrypted request when no key configured",
+			server:     plainTS,
+			headers:    encryptedHeaders,
+			body:       encrypt(body),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequestWithBody(t, tt.server, http.MethodPost, "/updates", tt.body, tt.headers)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	counter, err := store.GetCounterByKey(context.Background(), "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(2), counter)
+}
+
+// writeCertificate issues a certificate for 127.0.0.1 signed by parent, or
+// self-signed CA when parent is nil, and writes it with its key to dir.
+func writeCertificate(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	return cert, key
+}
+
+func TestRouterMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	writeCertificate(t, dir, "server", ca, caKey)
+	writeCertificate(t, dir, "agent", ca, caKey)
+	otherCA, otherKey := writeCertificate(t, dir, "other-ca", nil, nil)
+	writeCertificate(t, dir, "stranger", otherCA, otherKey)
+
+	cfg := types.ServerConfig{
+		StoreInterval: 300 * time.Second,
+		TLSCert:       path("server.crt"),
+		TLSKey:        path("server.key"),
+		TLSClientCA:   path("ca.crt"),
+	}
+	tlsConfig, err := functions.NewServerTLSConfig(&cfg)
+	require.NoError(t, err)
+	store := storage.NewMapStorage()
+	ts := httptest.NewUnstartedServer(NewRouter(&cfg, &store))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	newClient := func(cert string) *http.Client {
+		agentCfg := types.AgentConfig{Scheme: "https", TLSCA: path("ca.crt")}
+		if cert != "" {
+			agentCfg.TLSCert = path(cert + ".crt")
+			agentCfg.TLSKey = path(cert + ".key")
+		}
+		clientTLS, err := functions.NewAgentTLSConfig(&agentCfg)
+		require.NoError(t, err)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	}
+	body := `[{"id":"Requests","type":"counter","delta":1}]`
+
+	tests := []struct {
+		name       string
+		client     *http.Client
+		method     string
+		path       string
+		statusCode int
+	}{
+		{
+			name:       "should accept updates from enrolled agent",
+			client:     newClient("agent"),
+			method:     http.MethodPost,
+			path:       "/updates",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject updates without client certificate",
+			client:     newClient(""),
+			method:     http.MethodPost,
+			path:       "/updates",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "should serve reads without client certificate",
+			client:     newClient(""),
+			method:     http.MethodGet,
+			path:       "/",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject certificate of unknown CA",
+			client:     newClient("stranger"),
+			method:     http.MethodPost,
+			path:       "/updates",
+			statusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := tt.client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	counter, err := store.GetCounterByKey(context.Background(), "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(1), counter)
+}
+
+func TestRouterTrustedSubnet(t *testing.T) {
+	cfg := types.ServerConfig{
+		Address:       "localhost:8080",
+		StoreInterval: 300 * time.Second,
+		TrustedSubnet: "192.168.1.0/24",
+	}
+	store := storage.NewMapStorage()
+	ts := httptest.NewServer(NewRouter(&cfg, &store))
+	defer ts.Close()
+
+	body := `[{"id":"Requests","type":"counter","delta":1}]`
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		realIP     string
+		statusCode int
+	}{
+		{
+			name:       "should accept updates from trusted subnet",
+			method:     http.MethodPost,
+			path:       "/updates",
+			realIP:     "192.168.1.10",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "should reject updates from other subnet",
+			method:     http.MethodPost,
+			path:       "/updates",
+			realIP:     "10.0.0.1",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "should reject updates without X-Real-IP",
+			method:     http.MethodPost,
+			path:       "/update/counter/Requests/1",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "should reject malformed X-Real-IP",
+			method:     http.MethodPost,
+			path:       "/updates",
+			realIP:     "192.168.1.x",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "should serve reads from any address",
+			method:     http.MethodGet,
+			path:       "/",
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Content-Type": "application/json"}
+			if tt.realIP != "" {
+				headers["X-Real-IP"] = tt.realIP
+			}
+			resp, _ := testRequestWithBody(t, ts, tt.method, tt.path, body, headers)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	counter, err := store.GetCounterByKey(context.Background(), "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(1), counter)
+}
@@ -18,11 +18,15 @@ func NewRouter(cfg *types.ServerConfig, store *storage.Repository) chi.Router {
 	router.Use(middlewares.AppendConfigToContext(cfg))
 	router.Use(middlewares.AddStorage(store))
 	router.Use(middlewares.GzipCompress)
+	router.Use(middlewares.DecryptBody(cfg))
 	router.Use(middlewares.GzipDecompress)
 
-	router.With(middlewares.SaveMetricToFile).Route("/update", func(r chi.Router) {
-		r.Post("/", handlers.HandleUpdateMetricJSON)
-		r.Post("/{metricType}/{metricName}/{metricValue}", handlers.HandleUpdateMetric)
+	checkSignature := middlewares.CheckRequestSignature()
+	checkSubnet := middlewares.CheckTrustedSubnet(cfg)
+
+	router.With(checkSubnet, middlewares.RequireClientCert, middlewares.SaveMetricToFile, checkSignature).Route("/update", func(r chi.Router) {
+		r.With(middlewares.CheckHash).Post("/", handlers.HandleUpdateMetricJSON)
+		r.With(middlewares.CheckURLHash).Post("/{metricType}/{metricName}/{metricValue}", handlers.HandleUpdateMetric)
 	})
 	router.Route("/", func(r chi.Router) {
 		r.Get("/", handlers.HandleGetAllMetrics)
@@ -31,11 +35,17 @@ func NewRouter(cfg *types.ServerConfig, store *storage.Repository) chi.Router {
 		r.Post("/", handlers.HandleGetMetricJSON)
 		r.Get("/{metricType}/{metricName}", handlers.HandleGetMetric)
 	})
+	router.Route("/query_range", func(r chi.Router) {
+		r.Get("/", handlers.HandleQueryRange)
+	})
+	router.Route("/metrics", func(r chi.Router) {
+		r.Get("/", handlers.HandlePrometheusMetrics)
+	})
 	router.Route("/ping", func(r chi.Router) {
 		r.Get("/", handlers.HealthChecks)
 	})
-	router.Route("/updates", func(r chi.Router) {
-		r.Post("/", handlers.HandleUpdatesJSON)
+	router.With(checkSubnet, middlewares.RequireClientCert, checkSignature).Route("/updates", func(r chi.Router) {
+		r.With(middlewares.CheckHash).Post("/", handlers.HandleUpdatesJSON)
 	})
 	return router
 }
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// testRepository runs the same scenarios against a Repository implementation.
+// newRepo must return an empty repository.
+func testRepository(t *testing.T, newRepo func(t *testing.T) Repository) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	count := func(v uint64) *uint64 { return &v }
+	tests := []struct {
+		name  string
+		setup func(ctx context.Context, repo Repository) error
+		check func(t *testing.T, ctx context.Context, repo Repository)
+	}{
+		{
+			name: "not found",
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				_, err := repo.GetMetric(ctx, "counter", "Missing")
+				assert.ErrorIs(t, err, ErrNotFound)
+				_, err = repo.GetCounterByKey(ctx, "Missing")
+				assert.ErrorIs(t, err, ErrNotFound)
+				_, err = repo.GetGaugeByKey(ctx, "Missing")
+				assert.ErrorIs(t, err, ErrNotFound)
+				_, err = repo.GetSamples(ctx, "gauge", "Missing", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+				assert.ErrorIs(t, err, ErrNotFound)
+				metrics, err := repo.FindMetrics(ctx, "gauge", "Missing", nil)
+				require.NoError(t, err)
+				assert.Empty(t, metrics)
+			},
+		},
+		{
+			name: "type mismatch",
+			setup: func(ctx context.Context, repo Repository) error {
+				if err := repo.AddGauge(ctx, "Alloc", 1); err != nil {
+					return err
+				}
+				return repo.AddCounter(ctx, "PollCount", 1)
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				_, err := repo.GetCounterByKey(ctx, "Alloc")
+				assert.ErrorIs(t, err, ErrNotFound)
+				_, err = repo.GetMetric(ctx, "counter", "Alloc")
+				assert.ErrorIs(t, err, ErrNotFound)
+				_, err = repo.GetGaugeByKey(ctx, "PollCount")
+				assert.ErrorIs(t, err, ErrNotFound)
+				metrics, err := repo.FindMetrics(ctx, "counter", "Alloc", nil)
+				require.NoError(t, err)
+				assert.Empty(t, metrics)
+				_, err = repo.GetSamples(ctx, "counter", "Alloc", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+				assert.ErrorIs(t, err, ErrNotFound)
+			},
+		},
+		{
+			name: "same name with different types",
+			setup: func(ctx context.Context, repo Repository) error {
+				return repo.AddGauge(ctx, "Alloc", 1)
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				require.NoError(t, repo.AddCounter(ctx, "Alloc", 2))
+				require.NoError(t, repo.InsertMetrics(ctx, []types.Metric{
+					{ID: "Sys", MType: "gauge", Value: value(3)},
+					{ID: "Sys", MType: "counter", Delta: delta(4)},
+				}))
+
+				metrics, err := repo.AsMetrics(ctx)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, []types.Metric{
+					{ID: "Alloc", MType: "gauge", Value: value(1)},
+					{ID: "Alloc", MType: "counter", Delta: delta(2)},
+					{ID: "Sys", MType: "gauge", Value: value(3)},
+					{ID: "Sys", MType: "counter", Delta: delta(4)},
+				}, metrics.Metric)
+				val, err := repo.GetMetric(ctx, "gauge", "Alloc")
+				require.NoError(t, err)
+				assert.Equal(t, "1.000", val)
+				val, err = repo.GetMetric(ctx, "counter", "Alloc")
+				require.NoError(t, err)
+				assert.Equal(t, "2", val)
+
+				found, err := repo.FindMetrics(ctx, "counter", "Sys", nil)
+				require.NoError(t, err)
+				assert.Equal(t, []types.Metric{{ID: "Sys", MType: "counter", Delta: delta(4)}}, found)
+				samples, err := repo.GetSamples(ctx, "gauge", "Sys", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+				require.NoError(t, err)
+				require.Len(t, samples, 1)
+				assert.Equal(t, 3.0, samples[0].Value)
+			},
+		},
+		{
+			name: "metric without value",
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				for _, metric := range []types.Metric{
+					{ID: "PollCount", MType: "counter"},
+					{ID: "Alloc", MType: "gauge"},
+					{ID: "Latency", MType: "histogram", Count: count(1)},
+				} {
+					err := repo.InsertMetrics(ctx, []types.Metric{{ID: "Sys", MType: "gauge", Value: value(1)}, metric})
+					assert.ErrorIs(t, err, ErrInvalidMetric, metric.MType)
+				}
+				metrics, err := repo.AsMetrics(ctx)
+				require.NoError(t, err)
+				assert.Empty(t, metrics.Metric)
+			},
+		},
+		{
+			name: "distributions have no samples",
+			setup: func(ctx context.Context, repo Repository) error {
+				return repo.InsertMetrics(ctx, []types.Metric{
+					{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+					{ID: "Pause", MType: "summary", Sum: value(1), Count: count(1)},
+				})
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+				samples, err := repo.GetSamples(ctx, "histogram", "Latency", nil, from, to)
+				require.NoError(t, err)
+				assert.Empty(t, samples)
+				samples, err = repo.GetSamples(ctx, "summary", "Pause", nil, from, to)
+				require.NoError(t, err)
+				assert.Empty(t, samples)
+				_, err = repo.GetSamples(ctx, "histogram", "Pause", nil, from, to)
+				assert.ErrorIs(t, err, ErrNotFound)
+			},
+		},
+		{
+			name: "counter accumulation",
+			setup: func(ctx context.Context, repo Repository) error {
+				if err := repo.AddCounter(ctx, "PollCount", 2); err != nil {
+					return err
+				}
+				return repo.InsertMetrics(ctx, []types.Metric{{ID: "PollCount", MType: "counter", Delta: delta(3)}})
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				counter, err := repo.GetCounterByKey(ctx, "PollCount")
+				require.NoError(t, err)
+				assert.Equal(t, types.Counter(5), counter)
+				val, err := repo.GetMetric(ctx, "counter", "PollCount")
+				require.NoError(t, err)
+				assert.Equal(t, "5", val)
+			},
+		},
+		{
+			name: "gauge overwrite",
+			setup: func(ctx context.Context, repo Repository) error {
+				if err := repo.AddGauge(ctx, "Alloc", 1); err != nil {
+					return err
+				}
+				return repo.InsertMetrics(ctx, []types.Metric{{ID: "Alloc", MType: "gauge", Value: value(2.5)}})
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				gauge, err := repo.GetGaugeByKey(ctx, "Alloc")
+				require.NoError(t, err)
+				assert.Equal(t, types.Gauge(2.5), gauge)
+				val, err := repo.GetMetric(ctx, "gauge", "Alloc")
+				require.NoError(t, err)
+				assert.Equal(t, "2.500", val)
+			},
+		},
+		{
+			name: "batch insert",
+			setup: func(ctx context.Context, repo Repository) error {
+				return repo.InsertMetrics(ctx, []types.Metric{
+					{ID: "PollCount", MType: "counter", Delta: delta(1)},
+					{ID: "Alloc", MType: "gauge", Value: value(1)},
+					{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/"}},
+					{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+					{ID: "PollCount", MType: "counter", Delta: delta(2)},
+				})
+			},
+			check: func(t *testing.T, ctx context.Context, repo Repository) {
+				metrics, err := repo.AsMetrics(ctx)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, []types.Metric{
+					{ID: "PollCount", MType: "counter", Delta: delta(3)},
+					{ID: "Alloc", MType: "gauge", Value: value(1)},
+					{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/"}},
+					{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+				}, metrics.Metric)
+
+				found, err := repo.FindMetrics(ctx, "gauge", "DiskFree", map[string]string{"mountpoint": "/"})
+				require.NoError(t, err)
+				require.Len(t, found, 1)
+				assert.Equal(t, 2.0, *found[0].Value)
+				_, err = repo.GetGaugeByKey(ctx, "DiskFree")
+				assert.ErrorIs(t, err, ErrNotFound)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newRepo(t)
+			if tt.setup != nil {
+				require.NoError(t, tt.setup(ctx, repo))
+			}
+			tt.check(t, ctx, repo)
+		})
+	}
+}
+
+// testRepositoryHistory checks that a Repository keeps the latest
+// historySize samples of every series.
+func testRepositoryHistory(t *testing.T, newRepo func(t *testing.T, historySize int) Repository) {
+	ctx := context.Background()
+	store := newRepo(t, 3)
+	from := time.Now().Add(-time.Minute)
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.AddGauge(ctx, "Alloc", types.Gauge(i)))
+	}
+	require.NoError(t, store.AddCounter(ctx, "PollCount", 2))
+	require.NoError(t, store.AddCounter(ctx, "PollCount", 3))
+
+	samples, err := store.GetSamples(ctx, "gauge", "Alloc", nil, from, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, []float64{3, 4, 5}, []float64{samples[0].Value, samples[1].Value, samples[2].Value})
+
+	samples, err = store.GetSamples(ctx, "counter", "PollCount", nil, from, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, float64(5), samples[1].Value)
+
+	_, err = store.GetSamples(ctx, "counter", "Alloc", nil, from, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMapStorageConformance(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMapStorage()
+	})
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return newTestPostgresStorage(t)
+	})
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+const defaultHistorySize = 1024
+
+// historySize returns the number of samples database storages keep per
+// series.
+func historySize(cfg *types.ServerConfig) int {
+	if cfg.HistorySize > 0 {
+		return cfg.HistorySize
+	}
+	return defaultHistorySize
+}
+
+// sampleRing keeps the latest samples of a series, overwriting the oldest
+// one once full.
+type sampleRing struct {
+	samples []types.Sample
+	next    int
+	full    bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	return &sampleRing{samples: make([]types.Sample, size)}
+}
+
+func (r *sampleRing) add(sample types.Sample) {
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// between returns samples with from <= timestamp <= to in chronological order.
+func (r *sampleRing) between(from time.Time, to time.Time) []types.Sample {
+	var result []types.Sample
+	start, count := 0, r.next
+	if r.full {
+		start, count = r.next, len(r.samples)
+	}
+	for i := 0; i < count; i++ {
+		sample := r.samples[(start+i)%len(r.samples)]
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
@@ -1,101 +1,288 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"github.com/yurchenkosv/metric-service/internal/types"
+	"sync"
+	"time"
 )
 
+type series struct {
+	ID     string
+	Labels map[string]string
+}
+
 type mapStorage struct {
+	mutex         sync.RWMutex
 	GaugeMetric   map[string]types.Gauge
 	CounterMetric map[string]types.Counter
+	// LabeledSeries maps keys of labeled series to their name and labels.
+	LabeledSeries map[string]series
+	// DistributionMetric keeps histograms and summaries by type and series key.
+	DistributionMetric map[string]types.Metric
+	History            map[string]*sampleRing
+	historySize        int
 }
 
 func NewMapStorage() Repository {
+	return NewMapStorageWithHistory(defaultHistorySize)
+}
+
+// NewMapStorageWithHistory creates in-memory storage keeping up to historySize
+// samples per series.
+func NewMapStorageWithHistory(historySize int) Repository {
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
 	return &mapStorage{
-		GaugeMetric:   make(map[string]types.Gauge),
-		CounterMetric: make(map[string]types.Counter),
+		GaugeMetric:        make(map[string]types.Gauge),
+		CounterMetric:      make(map[string]types.Counter),
+		LabeledSeries:      make(map[string]series),
+		DistributionMetric: make(map[string]types.Metric),
+		History:            make(map[string]*sampleRing),
+		historySize:        historySize,
 	}
 }
 
-func (m *mapStorage) AddCounter(name string, val types.Counter) {
+func historyKey(mType string, key string) string {
+	return mType + ":" + key
+}
+
+func (m *mapStorage) record(mType string, key string, value float64) {
+	if m.History == nil {
+		m.History = make(map[string]*sampleRing)
+	}
+	if m.historySize <= 0 {
+		m.historySize = defaultHistorySize
+	}
+	ring, ok := m.History[historyKey(mType, key)]
+	if !ok {
+		ring = newSampleRing(m.historySize)
+		m.History[historyKey(mType, key)] = ring
+	}
+	ring.add(types.Sample{Timestamp: time.Now(), Value: value})
+}
+
+func (m *mapStorage) registerSeries(name string, labels map[string]string) string {
+	key := types.SeriesKey(name, labels)
+	if len(labels) == 0 {
+		return key
+	}
+	if m.LabeledSeries == nil {
+		m.LabeledSeries = make(map[string]series)
+	}
+	if _, ok := m.LabeledSeries[key]; !ok {
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		m.LabeledSeries[key] = series{ID: name, Labels: copied}
+	}
+	return key
+}
+
+func (m *mapStorage) toMetric(key string, mType string) types.Metric {
+	metric := types.Metric{ID: key, MType: mType}
+	if s, ok := m.LabeledSeries[key]; ok {
+		metric.ID = s.ID
+		metric.Labels = make(map[string]string, len(s.Labels))
+		for k, v := range s.Labels {
+			metric.Labels[k] = v
+		}
+	}
+	return metric
+}
+
+func (m *mapStorage) addCounter(name string, val types.Counter) {
 	if len(m.CounterMetric) == 0 {
 		m.CounterMetric = make(map[string]types.Counter)
 	}
 	m.CounterMetric[name] += val
+	m.record("counter", name, float64(m.CounterMetric[name]))
 }
 
-func (m *mapStorage) AddGauge(name string, val types.Gauge) {
+func (m *mapStorage) addGauge(name string, val types.Gauge) {
 	if len(m.GaugeMetric) == 0 {
 		m.GaugeMetric = make(map[string]types.Gauge)
 	}
 	m.GaugeMetric[name] = val
+	m.record("gauge", name, float64(val))
 }
 
-func (m *mapStorage) GetMetricByKey(key string) (string, error) {
-	if val, ok := m.CounterMetric[key]; ok {
-		return fmt.Sprintf("%v", val), nil
+func (m *mapStorage) addDistribution(key string, metric types.Metric) {
+	if m.DistributionMetric == nil {
+		m.DistributionMetric = make(map[string]types.Metric)
 	}
-	if val, ok := m.GaugeMetric[key]; ok {
-		return fmt.Sprintf("%.3f", val), nil
+	var stored *types.Metric
+	if val, ok := m.DistributionMetric[historyKey(metric.MType, key)]; ok {
+		stored = &val
+	}
+	m.DistributionMetric[historyKey(metric.MType, key)] = types.MergeDistribution(stored, metric)
+}
+
+func (m *mapStorage) AddCounter(ctx context.Context, name string, val types.Counter) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addCounter(name, val)
+	return nil
+}
+
+func (m *mapStorage) AddGauge(ctx context.Context, name string, val types.Gauge) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addGauge(name, val)
+	return nil
+}
+
+func (m *mapStorage) GetMetric(ctx context.Context, mType string, key string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	switch mType {
+	case "counter":
+		if val, ok := m.CounterMetric[key]; ok {
+			return fmt.Sprintf("%v", val), nil
+		}
+	case "gauge":
+		if val, ok := m.GaugeMetric[key]; ok {
+			return fmt.Sprintf("%.3f", val), nil
+		}
 	}
 	return "", ErrNotFound
 }
 
-func (m *mapStorage) GetCounterByKey(key string) (types.Counter, error) {
+func (m *mapStorage) GetCounterByKey(ctx context.Context, key string) (types.Counter, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	if val, ok := m.CounterMetric[key]; ok {
 		return val, nil
 	}
 	return 0, ErrNotFound
 }
 
-func (m *mapStorage) GetGaugeByKey(key string) (types.Gauge, error) {
+func (m *mapStorage) GetGaugeByKey(ctx context.Context, key string) (types.Gauge, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	if val, ok := m.GaugeMetric[key]; ok {
 		return val, nil
 	}
 	return 0, ErrNotFound
 }
 
-func (m *mapStorage) GetAllMetrics() string {
+func (m *mapStorage) GetAllMetrics(ctx context.Context) (string, error) {
 	var metrics string
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	for k, v := range m.CounterMetric {
 		metrics += fmt.Sprintf("key = %s value = %v\n", k, v)
 	}
 	for k, v := range m.GaugeMetric {
 		metrics += fmt.Sprintf("key = %s value = %v\n", k, v)
 	}
-	return metrics
+	for _, v := range m.DistributionMetric {
+		key := types.SeriesKey(v.ID, v.Labels)
+		metrics += fmt.Sprintf("key = %s count = %d sum = %v\n", key, *v.Count, *v.Sum)
+	}
+	return metrics, nil
 }
 
-func (m *mapStorage) AsMetrics() types.Metrics {
+func (m *mapStorage) AsMetrics(ctx context.Context) (types.Metrics, error) {
 	var metrics types.Metrics
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	for k, v := range m.CounterMetric {
 		counter := int64(v)
-		metrics.Metric = append(metrics.Metric, types.Metric{
-			ID:    k,
-			MType: "counter",
-			Delta: &counter,
-		})
+		metric := m.toMetric(k, "counter")
+		metric.Delta = &counter
+		metrics.Metric = append(metrics.Metric, metric)
 	}
 	for k, v := range m.GaugeMetric {
 		gauge := float64(v)
-		metrics.Metric = append(metrics.Metric, types.Metric{
-			ID:    k,
-			MType: "gauge",
-			Value: &gauge,
-		})
+		metric := m.toMetric(k, "gauge")
+		metric.Value = &gauge
+		metrics.Metric = append(metrics.Metric, metric)
+	}
+	for _, v := range m.DistributionMetric {
+		metrics.Metric = append(metrics.Metric, types.CopyDistribution(v))
 	}
-	return metrics
+	return metrics, nil
 }
 
-func (m *mapStorage) InsertMetrics(metrics []types.Metric) {
+// InsertMetrics stores the batch, or nothing of it if a metric in it has no
+// value.
+func (m *mapStorage) InsertMetrics(ctx context.Context, metrics []types.Metric) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, metric := range metrics {
+		if err := checkValue(metric); err != nil {
+			return err
+		}
+	}
 	for i := range metrics {
+		key := m.registerSeries(metrics[i].ID, metrics[i].Labels)
 		if metrics[i].MType == "counter" {
 			counter := *metrics[i].Delta
-			m.AddCounter(metrics[i].ID, types.Counter(counter))
+			m.addCounter(key, types.Counter(counter))
 		}
 		if metrics[i].MType == "gauge" {
 			gauge := *metrics[i].Value
-			m.AddGauge(metrics[i].ID, types.Gauge(gauge))
+			m.addGauge(key, types.Gauge(gauge))
+		}
+		if metrics[i].MType == "histogram" || metrics[i].MType == "summary" {
+			m.addDistribution(key, metrics[i])
+		}
+	}
+	return nil
+}
+
+func (m *mapStorage) FindMetrics(ctx context.Context, mType string, name string, matchers map[string]string) ([]types.Metric, error) {
+	var metrics []types.Metric
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if mType == "counter" {
+		for k, v := range m.CounterMetric {
+			metric := m.toMetric(k, mType)
+			if metric.ID != name || !types.MatchLabels(metric.Labels, matchers) {
+				continue
+			}
+			counter := int64(v)
+			metric.Delta = &counter
+			metrics = append(metrics, metric)
+		}
+	}
+	if mType == "gauge" {
+		for k, v := range m.GaugeMetric {
+			metric := m.toMetric(k, mType)
+			if metric.ID != name || !types.MatchLabels(metric.Labels, matchers) {
+				continue
+			}
+			gauge := float64(v)
+			metric.Value = &gauge
+			metrics = append(metrics, metric)
+		}
+	}
+	if mType == "histogram" || mType == "summary" {
+		for _, v := range m.DistributionMetric {
+			if v.MType != mType || v.ID != name || !types.MatchLabels(v.Labels, matchers) {
+				continue
+			}
+			metrics = append(metrics, types.CopyDistribution(v))
+		}
+	}
+	return metrics, nil
+}
+
+func (m *mapStorage) GetSamples(ctx context.Context, mType string, name string, labels map[string]string, from time.Time, to time.Time) ([]types.Sample, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key := historyKey(mType, types.SeriesKey(name, labels))
+	ring, ok := m.History[key]
+	if !ok {
+		// Histograms and summaries are stored without samples.
+		if _, stored := m.DistributionMetric[key]; stored {
+			return nil, nil
 		}
+		return nil, ErrNotFound
 	}
+	return ring.between(from, to), nil
 }
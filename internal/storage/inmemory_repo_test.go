@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestMapStorageConcurrentAccess(t *testing.T) {
+	const (
+		workers    = 16
+		iterations = 500
+	)
+	ctx := context.Background()
+	store := NewMapStorage()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(4)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				assert.NoError(t, store.AddCounter(ctx, "PollCount", 1))
+				assert.NoError(t, store.AddGauge(ctx, fmt.Sprintf("Gauge%d", w), types.Gauge(i)))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				delta := int64(1)
+				value := float64(i)
+				assert.NoError(t, store.InsertMetrics(ctx, []types.Metric{
+					{ID: "BatchCount", MType: "counter", Delta: &delta},
+					{ID: "BatchGauge", MType: "gauge", Value: &value},
+				}))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				store.GetCounterByKey(ctx, "PollCount")
+				store.GetGaugeByKey(ctx, "BatchGauge")
+				store.GetMetric(ctx, "counter", "BatchCount")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations/10; i++ {
+				_, err := store.AsMetrics(ctx)
+				assert.NoError(t, err)
+				_, err = store.GetAllMetrics(ctx)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	pollCount, err := store.GetCounterByKey(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(workers*iterations), pollCount)
+
+	batchCount, err := store.GetCounterByKey(ctx, "BatchCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(workers*iterations), batchCount)
+
+	metrics, err := store.AsMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metrics.Metric, workers+3)
+}
+
+func TestMapStorageLabels(t *testing.T) {
+	ctx := context.Background()
+	store := NewMapStorage()
+	first, second := 1.0, 2.0
+	delta := int64(4)
+
+	require.NoError(t, store.InsertMetrics(ctx, []types.Metric{
+		{ID: "Alloc", MType: "gauge", Value: &first, Labels: map[string]string{"host": "a"}},
+		{ID: "Alloc", MType: "gauge", Value: &second, Labels: map[string]string{"host": "b", "dc": "x"}},
+		{ID: "Alloc", MType: "counter", Delta: &delta, Labels: map[string]string{"host": "c"}},
+	}))
+
+	found, err := store.FindMetrics(ctx, "gauge", "Alloc", map[string]string{"host": "b"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, second, *found[0].Value)
+	assert.Equal(t, map[string]string{"host": "b", "dc": "x"}, found[0].Labels)
+
+	found, err = store.FindMetrics(ctx, "gauge", "Alloc", nil)
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	_, err = store.GetGaugeByKey(ctx, "Alloc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	metrics, err := store.AsMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, metrics.Metric, 3)
+	for _, metric := range metrics.Metric {
+		assert.Equal(t, "Alloc", metric.ID)
+		assert.NotEmpty(t, metric.Labels)
+	}
+}
+
+func TestMapStorageHistory(t *testing.T) {
+	testRepositoryHistory(t, func(t *testing.T, historySize int) Repository {
+		return NewMapStorageWithHistory(historySize)
+	})
+}
+
+func TestMapStorageDistributions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMapStorage()
+	sum, count := 3.0, uint64(2)
+	histogram := types.Metric{
+		ID:    "Latency",
+		MType: "histogram",
+		Sum:   &sum,
+		Count: &count,
+		Buckets: []types.Bucket{
+			{UpperBound: 1, Count: 1},
+			{UpperBound: 5, Count: 2},
+		},
+	}
+	summary := types.Metric{
+		ID:        "Pause",
+		MType:     "summary",
+		Sum:       &sum,
+		Count:     &count,
+		Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1.5}},
+	}
+
+	require.NoError(t, store.InsertMetrics(ctx, []types.Metric{histogram, summary}))
+	require.NoError(t, store.InsertMetrics(ctx, []types.Metric{histogram, summary}))
+
+	found, err := store.FindMetrics(ctx, "histogram", "Latency", nil)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, uint64(4), *found[0].Count)
+	assert.Equal(t, 6.0, *found[0].Sum)
+	assert.Equal(t, []types.Bucket{{UpperBound: 1, Count: 2}, {UpperBound: 5, Count: 4}}, found[0].Buckets)
+
+	found, err = store.FindMetrics(ctx, "summary", "Pause", nil)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, uint64(2), *found[0].Count)
+	assert.Equal(t, summary.Quantiles, found[0].Quantiles)
+
+	histogram.Buckets = []types.Bucket{{UpperBound: 10, Count: 2}}
+	require.NoError(t, store.InsertMetrics(ctx, []types.Metric{histogram}))
+	found, err = store.FindMetrics(ctx, "histogram", "Latency", nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), *found[0].Count)
+	assert.Equal(t, histogram.Buckets, found[0].Buckets)
+}
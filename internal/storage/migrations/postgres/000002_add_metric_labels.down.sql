@@ -0,0 +1,4 @@
+DELETE FROM metrics WHERE labels <> '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_metric_id_labels_key;
+ALTER TABLE metrics ADD CONSTRAINT metrics_metric_id_key UNIQUE (metric_id);
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
\ No newline at end of file
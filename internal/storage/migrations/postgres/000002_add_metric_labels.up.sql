@@ -0,0 +1,3 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_metric_id_key;
+ALTER TABLE metrics ADD CONSTRAINT metrics_metric_id_labels_key UNIQUE (metric_id, labels);
\ No newline at end of file
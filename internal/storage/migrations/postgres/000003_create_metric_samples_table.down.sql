@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS metrics_record_sample ON metrics;
+DROP FUNCTION IF EXISTS record_metric_sample();
+DROP TABLE IF EXISTS metric_samples;
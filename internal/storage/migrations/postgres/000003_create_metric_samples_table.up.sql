@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS metric_samples(
+    id BIGSERIAL PRIMARY KEY,
+    metric_id VARCHAR(256) NOT NULL,
+    metric_type VARCHAR(50) NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    value DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+    ON metric_samples(metric_type, metric_id, created_at);
+
+CREATE OR REPLACE FUNCTION record_metric_sample() RETURNS TRIGGER AS $$
+BEGIN
+    IF COALESCE(NEW.metric_delta::DOUBLE PRECISION, NEW.metric_value) IS NOT NULL THEN
+        INSERT INTO metric_samples(metric_id, metric_type, labels, value)
+        VALUES (NEW.metric_id, NEW.metric_type, NEW.labels,
+                COALESCE(NEW.metric_delta::DOUBLE PRECISION, NEW.metric_value));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER metrics_record_sample
+    AFTER INSERT OR UPDATE ON metrics
+    FOR EACH ROW EXECUTE PROCEDURE record_metric_sample();
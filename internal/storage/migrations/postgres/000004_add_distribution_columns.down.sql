@@ -0,0 +1,6 @@
+DELETE FROM metrics WHERE metric_type IN ('histogram', 'summary');
+ALTER TABLE metrics
+    DROP COLUMN IF EXISTS metric_sum,
+    DROP COLUMN IF EXISTS metric_count,
+    DROP COLUMN IF EXISTS buckets,
+    DROP COLUMN IF EXISTS quantiles;
@@ -0,0 +1,5 @@
+ALTER TABLE metrics
+    ADD COLUMN IF NOT EXISTS metric_sum DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS metric_count BIGINT,
+    ADD COLUMN IF NOT EXISTS buckets JSONB,
+    ADD COLUMN IF NOT EXISTS quantiles JSONB;
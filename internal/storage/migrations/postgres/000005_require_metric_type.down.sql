@@ -0,0 +1 @@
+ALTER TABLE metrics ALTER COLUMN metric_type DROP NOT NULL;
@@ -0,0 +1,10 @@
+-- Rows stored before the type was always written get it from the column
+-- holding their value; rows without a value can not be typed and are dropped.
+UPDATE metrics
+SET metric_type = CASE
+        WHEN metric_delta IS NOT NULL THEN 'counter'
+        WHEN metric_value IS NOT NULL THEN 'gauge'
+    END
+WHERE metric_type IS NULL;
+DELETE FROM metrics WHERE metric_type IS NULL;
+ALTER TABLE metrics ALTER COLUMN metric_type SET NOT NULL;
@@ -0,0 +1,8 @@
+-- Only one type per (metric_id, labels) fits the old key; the newest row wins.
+DELETE FROM metrics older
+USING metrics newer
+WHERE older.metric_id = newer.metric_id
+    AND older.labels = newer.labels
+    AND older.id < newer.id;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_metric_type_metric_id_labels_key;
+ALTER TABLE metrics ADD CONSTRAINT metrics_metric_id_labels_key UNIQUE (metric_id, labels);
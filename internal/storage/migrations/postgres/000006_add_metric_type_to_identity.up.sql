@@ -0,0 +1,5 @@
+-- A series is identified by its type, name and labels, so a gauge and a
+-- counter with the same name are stored side by side.
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_metric_id_labels_key;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_metric_type_metric_id_labels_key;
+ALTER TABLE metrics ADD CONSTRAINT metrics_metric_type_metric_id_labels_key UNIQUE (metric_type, metric_id, labels);
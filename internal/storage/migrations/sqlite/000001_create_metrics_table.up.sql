@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS metrics(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    metric_id TEXT NOT NULL,
+    metric_type TEXT NOT NULL,
+    metric_delta INTEGER,
+    metric_value REAL,
+    metric_sum REAL,
+    metric_count INTEGER,
+    buckets TEXT,
+    quantiles TEXT,
+    hash TEXT,
+    labels TEXT NOT NULL DEFAULT '{}',
+    UNIQUE (metric_id, labels)
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS metrics_record_sample_update;
+DROP TRIGGER IF EXISTS metrics_record_sample_insert;
+DROP TABLE IF EXISTS metric_samples;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS metric_samples(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    metric_id TEXT NOT NULL,
+    metric_type TEXT NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    value REAL NOT NULL,
+    -- unix time in milliseconds
+    created_at INTEGER NOT NULL DEFAULT (CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER))
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+    ON metric_samples(metric_type, metric_id, labels, created_at);
+
+CREATE TRIGGER IF NOT EXISTS metrics_record_sample_insert
+    AFTER INSERT ON metrics
+    WHEN COALESCE(NEW.metric_delta, NEW.metric_value) IS NOT NULL
+BEGIN
+    INSERT INTO metric_samples(metric_id, metric_type, labels, value)
+    VALUES (NEW.metric_id, NEW.metric_type, NEW.labels, COALESCE(NEW.metric_delta, NEW.metric_value));
+END;
+
+CREATE TRIGGER IF NOT EXISTS metrics_record_sample_update
+    AFTER UPDATE ON metrics
+    WHEN COALESCE(NEW.metric_delta, NEW.metric_value) IS NOT NULL
+BEGIN
+    INSERT INTO metric_samples(metric_id, metric_type, labels, value)
+    VALUES (NEW.metric_id, NEW.metric_type, NEW.labels, COALESCE(NEW.metric_delta, NEW.metric_value));
+END;
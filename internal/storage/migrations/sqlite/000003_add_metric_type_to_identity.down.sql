@@ -0,0 +1,38 @@
+-- Only one type per (metric_id, labels) fits the old key; the newest row wins.
+CREATE TABLE metrics_rebuilt(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    metric_id TEXT NOT NULL,
+    metric_type TEXT NOT NULL,
+    metric_delta INTEGER,
+    metric_value REAL,
+    metric_sum REAL,
+    metric_count INTEGER,
+    buckets TEXT,
+    quantiles TEXT,
+    hash TEXT,
+    labels TEXT NOT NULL DEFAULT '{}',
+    UNIQUE (metric_id, labels)
+);
+INSERT INTO metrics_rebuilt(id, metric_id, metric_type, metric_delta, metric_value, metric_sum, metric_count, buckets, quantiles, hash, labels)
+SELECT id, metric_id, metric_type, metric_delta, metric_value, metric_sum, metric_count, buckets, quantiles, hash, labels
+FROM metrics
+WHERE id IN (SELECT MAX(id) FROM metrics GROUP BY metric_id, labels);
+DROP TABLE metrics;
+ALTER TABLE metrics_rebuilt RENAME TO metrics;
+
+-- Dropping the table dropped its triggers.
+CREATE TRIGGER IF NOT EXISTS metrics_record_sample_insert
+    AFTER INSERT ON metrics
+    WHEN COALESCE(NEW.metric_delta, NEW.metric_value) IS NOT NULL
+BEGIN
+    INSERT INTO metric_samples(metric_id, metric_type, labels, value)
+    VALUES (NEW.metric_id, NEW.metric_type, NEW.labels, COALESCE(NEW.metric_delta, NEW.metric_value));
+END;
+
+CREATE TRIGGER IF NOT EXISTS metrics_record_sample_update
+    AFTER UPDATE ON metrics
+    WHEN COALESCE(NEW.metric_delta, NEW.metric_value) IS NOT NULL
+BEGIN
+    INSERT INTO metric_samples(metric_id, metric_type, labels, value)
+    VALUES (NEW.metric_id, NEW.metric_type, NEW.labels, COALESCE(NEW.metric_delta, NEW.metric_value));
+END;
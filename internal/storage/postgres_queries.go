@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// Statements prepared on every pooled connection. pgx runs a prepared
+// statement when its name is passed instead of SQL.
+const (
+	stmtGetCounter         = "get_counter"
+	stmtGetGauge           = "get_gauge"
+	stmtAllMetrics         = "all_metrics"
+	stmtUpsertScalar       = "upsert_scalar"
+	stmtUpsertDistribution = "upsert_distribution"
+	stmtLockSeries         = "lock_series"
+	stmtLockDistribution   = "lock_distribution"
+	stmtFindMetrics        = "find_metrics"
+	stmtGetSamples         = "get_samples"
+	stmtSeriesExists       = "series_exists"
+	stmtTrimSamples        = "trim_samples"
+)
+
+const metricColumns = `metric_id, metric_type, metric_delta, metric_value,
+	metric_sum, metric_count, buckets, quantiles, hash, labels`
+
+var statements = map[string]string{
+	stmtGetCounter: `
+		SELECT metric_delta
+		FROM metrics
+		WHERE metric_type='counter' AND metric_id=$1 AND labels='{}'::jsonb`,
+	stmtGetGauge: `
+		SELECT metric_value
+		FROM metrics
+		WHERE metric_type='gauge' AND metric_id=$1 AND labels='{}'::jsonb`,
+	stmtAllMetrics: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		ORDER BY CASE metric_type WHEN 'counter' THEN 0 WHEN 'gauge' THEN 1 ELSE 2 END, id`,
+	stmtUpsertScalar: `
+		INSERT INTO metrics(metric_id, metric_type, metric_delta, metric_value, hash, labels)
+		VALUES($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (metric_type, metric_id, labels) DO UPDATE
+		SET metric_delta=metrics.metric_delta+$3,
+			metric_value=$4,
+			hash=$5`,
+	stmtUpsertDistribution: `
+		INSERT INTO metrics(metric_id, metric_type, metric_sum, metric_count, buckets, quantiles, hash, labels)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (metric_type, metric_id, labels) DO UPDATE
+		SET metric_sum=$3,
+			metric_count=$4,
+			buckets=$5,
+			quantiles=$6,
+			hash=$7`,
+	stmtLockSeries: `
+		SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2 || $3::jsonb::text))`,
+	stmtLockDistribution: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		WHERE metric_type=$1 AND metric_id=$2 AND labels=$3::jsonb
+		FOR UPDATE`,
+	stmtFindMetrics: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		WHERE metric_type=$1 AND metric_id=$2 AND labels @> $3::jsonb`,
+	stmtGetSamples: `
+		SELECT created_at, value
+		FROM metric_samples
+		WHERE metric_type=$1 AND metric_id=$2 AND labels=$3::jsonb
+			AND created_at BETWEEN $4 AND $5
+		ORDER BY created_at, id`,
+	stmtSeriesExists: `
+		SELECT EXISTS(SELECT 1 FROM metrics WHERE metric_type=$1 AND metric_id=$2 AND labels=$3::jsonb)`,
+	stmtTrimSamples: `
+		DELETE FROM metric_samples
+		WHERE metric_type=$1 AND metric_id=$2 AND labels=$3::jsonb
+			AND id <= (
+				SELECT id FROM metric_samples
+				WHERE metric_type=$1 AND metric_id=$2 AND labels=$3::jsonb
+				ORDER BY id DESC
+				OFFSET $4 LIMIT 1)`,
+}
+
+// prepareStatements prepares every statement on a new pooled connection.
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range statements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return fmt.Errorf("prepare %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// scanMetric reads a row selected with metricColumns. Nullable columns are
+// scanned into pointers and stay nil for NULL.
+func scanMetric(row pgx.Row) (types.Metric, error) {
+	var metric types.Metric
+	var hash *string
+	var labels map[string]string
+	err := row.Scan(
+		&metric.ID,
+		&metric.MType,
+		&metric.Delta,
+		&metric.Value,
+		&metric.Sum,
+		&metric.Count,
+		&metric.Buckets,
+		&metric.Quantiles,
+		&hash,
+		&labels,
+	)
+	if err != nil {
+		return metric, err
+	}
+	if len(labels) > 0 {
+		metric.Labels = labels
+	}
+	if hash != nil {
+		metric.Hash = *hash
+	}
+	return metric, nil
+}
+
+// nullString maps an empty string to NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func labelsOrEmpty(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
@@ -4,250 +4,329 @@ import (
 	"context"
 	"fmt"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/yurchenkosv/metric-service/internal/types"
-	"log"
-	"os"
+	"strings"
+	"time"
 )
 
 type PostgresStorage struct {
-	Conn string
+	Pool *pgxpool.Pool
+	// historySize is the number of samples kept per series.
+	historySize int
 }
 
-func NewPostgresStorage(cfg *types.ServerConfig) Repository {
-	conn, err := pgx.Connect(context.Background(), cfg.DBDsn)
+func NewPostgresStorage(ctx context.Context, cfg *types.ServerConfig) (*PostgresStorage, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DBDsn)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		return nil, err
+	}
+	if cfg.DBMaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.DBMaxConns)
+	}
+	if cfg.DBMinConns > 0 {
+		poolConfig.MinConns = int32(cfg.DBMinConns)
+	}
+	if cfg.DBMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.DBMaxConnLifetime
+	}
+	if cfg.DBHealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.DBHealthCheckPeriod
 	}
-	defer conn.Close(context.Background())
 
-	return &PostgresStorage{Conn: cfg.DBDsn}
-}
+	poolConfig.AfterConnect = prepareStatements
 
-func (p *PostgresStorage) AddCounter(name string, counter types.Counter) {
-	conn, err := pgx.Connect(context.Background(), p.Conn)
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-	}
-	defer conn.Close(context.Background())
-	query := `
-		INSERT INTO metrics(
-		metric_id,
-		metric_type,
-		metric_delta 
-		)
-		VALUES($1, $2, $3)
-		ON CONFLICT (metric_id) DO UPDATE
-		SET metric_delta=metrics.metric_delta+$3;
-	`
-	_, err = conn.Exec(context.Background(), query, name, "counter", int(counter))
-	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+	return &PostgresStorage{Pool: pool, historySize: historySize(cfg)}, nil
 }
 
-func (p *PostgresStorage) AddGauge(name string, gauge types.Gauge) {
-	conn, err := pgx.Connect(context.Background(), p.Conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+func (p *PostgresStorage) Ping(ctx context.Context) error {
+	return p.Pool.Ping(ctx)
+}
+
+func (p *PostgresStorage) Close() {
+	p.Pool.Close()
+}
+
+func (p *PostgresStorage) AddCounter(ctx context.Context, name string, counter types.Counter) error {
+	delta := int64(counter)
+	return p.InsertMetrics(ctx, []types.Metric{{ID: name, MType: "counter", Delta: &delta}})
+}
+
+func (p *PostgresStorage) AddGauge(ctx context.Context, name string, gauge types.Gauge) error {
+	value := float64(gauge)
+	return p.InsertMetrics(ctx, []types.Metric{{ID: name, MType: "gauge", Value: &value}})
+}
+
+func (p *PostgresStorage) GetMetric(ctx context.Context, mType string, name string) (string, error) {
+	switch mType {
+	case "counter":
+		counter, err := p.GetCounterByKey(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", counter), nil
+	case "gauge":
+		gauge, err := p.GetGaugeByKey(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%.3f", gauge), nil
 	}
-	defer conn.Close(context.Background())
+	return "", ErrNotFound
+}
 
-	query := `
-		INSERT INTO metrics(
-			metric_id,
-			metric_type,
-			metric_value 
-		)
-		VALUES($1, $2, $3)
-		ON CONFLICT (metric_id) DO UPDATE
-		SET metric_value=$3;
-	`
-	_, err = conn.Exec(context.Background(), query, name, "gauge", float64(gauge))
+func (p *PostgresStorage) GetCounterByKey(ctx context.Context, name string) (types.Counter, error) {
+	var counter *int64
+	err := p.Pool.QueryRow(ctx, stmtGetCounter, name).Scan(&counter)
+	if err == pgx.ErrNoRows || (err == nil && counter == nil) {
+		return 0, ErrNotFound
+	}
 	if err != nil {
-		log.Println(err)
+		return 0, err
 	}
+	return types.Counter(*counter), nil
 }
 
-func (p *PostgresStorage) GetMetricByKey(name string) (string, error) {
-	var counter string
-	var gauge string
-	conn, err := pgx.Connect(context.Background(), p.Conn)
+func (p *PostgresStorage) GetGaugeByKey(ctx context.Context, name string) (types.Gauge, error) {
+	var gauge *float64
+	err := p.Pool.QueryRow(ctx, stmtGetGauge, name).Scan(&gauge)
+	if err == pgx.ErrNoRows || (err == nil && gauge == nil) {
+		return 0, ErrNotFound
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		return 0, err
 	}
-	defer conn.Close(context.Background())
+	return types.Gauge(*gauge), nil
+}
 
-	query := "SELECT metric_delta, metric_value FROM metrics WHERE metric_id = $1"
-	result, err := conn.Query(context.Background(), query, name)
+func (p *PostgresStorage) GetAllMetrics(ctx context.Context) (string, error) {
+	var builder strings.Builder
+	result, err := p.Pool.Query(ctx, stmtAllMetrics)
 	if err != nil {
 		return "", err
 	}
 	defer result.Close()
 
 	for result.Next() {
-		result.Scan(&counter, &gauge)
-		if counter != "" {
-			return counter, nil
-		} else {
-			return gauge, nil
+		metric, err := scanMetric(result)
+		if err != nil {
+			return "", err
+		}
+		key := types.SeriesKey(metric.ID, metric.Labels)
+		switch {
+		case metric.MType == "counter" && metric.Delta != nil:
+			fmt.Fprintf(&builder, "%s = %d \n", key, *metric.Delta)
+		case metric.MType == "gauge" && metric.Value != nil:
+			fmt.Fprintf(&builder, "%s = %v \n", key, *metric.Value)
+		case metric.Sum != nil && metric.Count != nil:
+			fmt.Fprintf(&builder, "%s count = %d sum = %v \n", key, *metric.Count, *metric.Sum)
 		}
 	}
-
-	return "", ErrNotFound
+	return builder.String(), result.Err()
 }
 
-func (p *PostgresStorage) GetCounterByKey(name string) (types.Counter, error) {
-	var counter types.Counter
-	conn, err := pgx.Connect(context.Background(), p.Conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-	}
-	defer conn.Close(context.Background())
-
-	query := "SELECT metric_delta FROM metrics WHERE metric_id=$1"
-
-	result, err := conn.Query(context.Background(), query, name)
+func (p *PostgresStorage) AsMetrics(ctx context.Context) (types.Metrics, error) {
+	var metrics types.Metrics
+	result, err := p.Pool.Query(ctx, stmtAllMetrics)
 	if err != nil {
-		return 0, err
+		return metrics, err
 	}
 	defer result.Close()
 
 	for result.Next() {
-		result.Scan(&counter)
+		metric, err := scanMetric(result)
+		if err != nil {
+			return metrics, err
+		}
+		metrics.Metric = append(metrics.Metric, metric)
 	}
-	return counter, nil
+	return metrics, result.Err()
 }
 
-func (p *PostgresStorage) GetGaugeByKey(name string) (types.Gauge, error) {
-	var gauge types.Gauge
-	conn, err := pgx.Connect(context.Background(), p.Conn)
+// InsertMetrics stores the batch in one transaction: either every metric is
+// stored or none is, and the returned *BatchError names the metric that
+// failed. Series repeated in the batch are merged first, so each row is
+// written once.
+func (p *PostgresStorage) InsertMetrics(ctx context.Context, metrics []types.Metric) error {
+	metrics, err := aggregateBatch(metrics)
+	if err != nil || len(metrics) == 0 {
+		return err
+	}
+	tx, err := p.Pool.Begin(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		return err
 	}
-	defer conn.Close(context.Background())
-
-	query := "SELECT metric_value FROM metrics WHERE metric_id=$1"
+	defer tx.Rollback(context.Background())
 
-	result, err := conn.Query(context.Background(), query, name)
+	if err = lockSeries(ctx, tx, metrics); err != nil {
+		return err
+	}
+	stored, err := lockDistributions(ctx, tx, metrics)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer result.Close()
 
-	for result.Next() {
-		result.Scan(&gauge)
+	batch := &pgx.Batch{}
+	for _, metric := range metrics {
+		if metric.MType == "histogram" || metric.MType == "summary" {
+			queueDistribution(batch, stored[batchKey(metric)], metric)
+			continue
+		}
+		batch.Queue(stmtUpsertScalar,
+			metric.ID,
+			metric.MType,
+			metric.Delta,
+			metric.Value,
+			nullString(metric.Hash),
+			labelsOrEmpty(metric.Labels),
+		)
+		batch.Queue(stmtTrimSamples, metric.MType, metric.ID, labelsOrEmpty(metric.Labels), p.historySize)
 	}
+	results := tx.SendBatch(ctx, batch)
+	for _, metric := range metrics {
+		queued := 1
+		if metric.MType == "counter" || metric.MType == "gauge" {
+			queued = 2
+		}
+		for i := 0; i < queued; i++ {
+			if _, err = results.Exec(); err != nil {
+				results.Close()
+				return &BatchError{ID: metric.ID, Labels: metric.Labels, Err: err}
+			}
+		}
+	}
+	if err = results.Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
-	return gauge, nil
+// lockSeries locks every series of the batch for the rest of the transaction,
+// including ones not stored yet, so concurrent batches creating the same
+// distribution do not overwrite each other.
+func lockSeries(ctx context.Context, tx pgx.Tx, metrics []types.Metric) error {
+	batch := &pgx.Batch{}
+	for _, metric := range metrics {
+		batch.Queue(stmtLockSeries, metric.MType, metric.ID, labelsOrEmpty(metric.Labels))
+	}
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	for _, metric := range metrics {
+		if _, err := results.Exec(); err != nil {
+			return &BatchError{ID: metric.ID, Labels: metric.Labels, Err: err}
+		}
+	}
+	return results.Close()
 }
 
-func (p *PostgresStorage) GetAllMetrics() string {
-	var metrics string
-	conn, err := pgx.Connect(context.Background(), p.Conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+// lockDistributions reads the stored histograms and summaries the batch
+// merges with. The rows stay locked until the transaction ends so concurrent
+// merges do not lose observations.
+func lockDistributions(ctx context.Context, tx pgx.Tx, metrics []types.Metric) (map[string]*types.Metric, error) {
+	batch := &pgx.Batch{}
+	var distributions []types.Metric
+	for _, metric := range metrics {
+		if metric.MType == "histogram" || metric.MType == "summary" {
+			batch.Queue(stmtLockDistribution, metric.MType, metric.ID, labelsOrEmpty(metric.Labels))
+			distributions = append(distributions, metric)
+		}
+	}
+	stored := make(map[string]*types.Metric, len(distributions))
+	if len(distributions) == 0 {
+		return stored, nil
 	}
-	defer conn.Close(context.Background())
-	query := "SELECT metric_id, metric_delta FROM metrics WHERE metric_type='counter'"
 
-	result, err := conn.Query(context.Background(), query)
-	if err != nil {
-		log.Println(err)
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	for _, metric := range distributions {
+		row, err := scanMetric(results.QueryRow())
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, &BatchError{ID: metric.ID, Labels: metric.Labels, Err: err}
+		}
+		row = types.CopyDistribution(row)
+		stored[batchKey(metric)] = &row
 	}
-	defer result.Close()
+	return stored, results.Close()
+}
 
-	for result.Next() {
-		var value, key string
-		result.Scan(&key, &value)
-		metrics = metrics + fmt.Sprintf("%s = %s \n", key, value)
+// queueDistribution queues an upsert of a histogram or summary merged with
+// the stored one.
+func queueDistribution(batch *pgx.Batch, stored *types.Metric, metric types.Metric) {
+	merged := types.MergeDistribution(stored, metric)
+	var buckets, quantiles interface{}
+	if len(merged.Buckets) > 0 {
+		buckets = merged.Buckets
 	}
+	if len(merged.Quantiles) > 0 {
+		quantiles = merged.Quantiles
+	}
+	batch.Queue(stmtUpsertDistribution,
+		merged.ID,
+		merged.MType,
+		merged.Sum,
+		merged.Count,
+		buckets,
+		quantiles,
+		nullString(metric.Hash),
+		labelsOrEmpty(metric.Labels),
+	)
+}
 
-	query = "SELECT metric_id, metric_value FROM metrics WHERE metric_type='gauge'"
-	result, err = conn.Query(context.Background(), query)
+func (p *PostgresStorage) FindMetrics(ctx context.Context, mType string, name string, matchers map[string]string) ([]types.Metric, error) {
+	var metrics []types.Metric
+	result, err := p.Pool.Query(ctx, stmtFindMetrics, mType, name, labelsOrEmpty(matchers))
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 	defer result.Close()
 
 	for result.Next() {
-		var value, key string
-		result.Scan(&key, &value)
-		metrics = metrics + fmt.Sprintf("%s = %s \n", key, value)
+		metric, err := scanMetric(result)
+		if err != nil {
+			return nil, err
+		}
+		metric.Hash = ""
+		metrics = append(metrics, metric)
 	}
-	return metrics
+	return metrics, result.Err()
 }
 
-func (p *PostgresStorage) AsMetrics() types.Metrics {
-	var metrics types.Metrics
-	conn, err := pgx.Connect(context.Background(), p.Conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-	}
-	defer conn.Close(context.Background())
-	query := "SELECT metric_id, metric_type, metric_delta, metric_value, hash FROM metrics"
-
-	result, err := conn.Query(context.Background(), query)
+func (p *PostgresStorage) GetSamples(ctx context.Context, mType string, name string, labels map[string]string, from time.Time, to time.Time) ([]types.Sample, error) {
+	var samples []types.Sample
+	result, err := p.Pool.Query(ctx, stmtGetSamples, mType, name, labelsOrEmpty(labels), from, to)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 	defer result.Close()
 
 	for result.Next() {
-		var metricID, metricType, hash string
-		var metricDelta *int64
-		var metricValue *float64
-		result.Scan(&metricID, &metricType, &metricDelta, &metricValue, &hash)
-		metrics.Metric = append(metrics.Metric, types.Metric{
-			ID:    metricID,
-			MType: metricType,
-			Delta: metricDelta,
-			Value: metricValue,
-			Hash:  hash,
-		})
-	}
-	return metrics
-}
-
-func (p *PostgresStorage) InsertMetrics(metrics []types.Metric) {
-	conn, err := pgx.Connect(context.Background(), p.Conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-	}
-	defer conn.Close(context.Background())
-	tx, err := conn.Begin(context.Background())
-	if err != nil {
-		log.Println(err)
-	}
-	for i := range metrics {
-		query := `
-		INSERT INTO metrics(
-			metric_id,
-			metric_type,
-			metric_delta,
-			metric_value,
-			hash
-		)
-		VALUES($1, $2, $3, $4, $5)
-		ON CONFLICT (metric_id) DO UPDATE
-		SET metric_delta=metrics.metric_delta+$3,
-			metric_value=$4,
-			hash=$5;
-		`
-		_, err = tx.Exec(context.Background(),
-			query,
-			metrics[i].ID,
-			metrics[i].MType,
-			metrics[i].Delta,
-			metrics[i].Value,
-			metrics[i].Hash,
-		)
-		if err != nil {
-			log.Println(err)
+		var sample types.Sample
+		if err = result.Scan(&sample.Timestamp, &sample.Value); err != nil {
+			return nil, err
 		}
+		samples = append(samples, sample)
+	}
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+	if len(samples) > 0 {
+		return samples, nil
 	}
-	err = tx.Commit(context.Background())
+
+	var exists bool
+	err = p.Pool.QueryRow(ctx, stmtSeriesExists, mType, name, labelsOrEmpty(labels)).Scan(&exists)
 	if err != nil {
-		log.Println(err)
-		tx.Rollback(context.Background())
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
 	}
+	return samples, nil
 }
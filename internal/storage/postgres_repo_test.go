@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+func TestAggregateBatch(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	count := func(v uint64) *uint64 { return &v }
+	tests := []struct {
+		name    string
+		metrics []types.Metric
+		want    []types.Metric
+		wantErr error
+	}{
+		{
+			name: "sums counter deltas",
+			metrics: []types.Metric{
+				{ID: "PollCount", MType: "counter", Delta: delta(1)},
+				{ID: "PollCount", MType: "counter", Delta: delta(2)},
+			},
+			want: []types.Metric{
+				{ID: "PollCount", MType: "counter", Delta: delta(3)},
+			},
+		},
+		{
+			name: "keeps last gauge",
+			metrics: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: value(1)},
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+			},
+			want: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+			},
+		},
+		{
+			name: "keeps series apart and sorted",
+			metrics: []types.Metric{
+				{ID: "DiskFree", MType: "gauge", Value: value(1), Labels: map[string]string{"mountpoint": "/"}},
+				{ID: "Alloc", MType: "gauge", Value: value(1)},
+				{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/home"}},
+			},
+			want: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: value(1)},
+				{ID: "DiskFree", MType: "gauge", Value: value(1), Labels: map[string]string{"mountpoint": "/"}},
+				{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/home"}},
+			},
+		},
+		{
+			name: "sums histograms",
+			metrics: []types.Metric{
+				{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+				{ID: "Latency", MType: "histogram", Sum: value(5), Count: count(2), Buckets: []types.Bucket{{UpperBound: 1, Count: 0}}},
+			},
+			want: []types.Metric{
+				{ID: "Latency", MType: "histogram", Sum: value(6), Count: count(3), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+			},
+		},
+		{
+			name: "keeps types of one name apart",
+			metrics: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+				{ID: "Alloc", MType: "counter", Delta: delta(1)},
+				{ID: "Alloc", MType: "counter", Delta: delta(1)},
+			},
+			want: []types.Metric{
+				{ID: "Alloc", MType: "counter", Delta: delta(2)},
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+			},
+		},
+		{
+			name: "rejects metric without value",
+			metrics: []types.Metric{
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+				{ID: "PollCount", MType: "counter"},
+			},
+			wantErr: ErrInvalidMetric,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aggregateBatch(tt.metrics)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// newTestPostgresStorage connects to the database in TEST_DATABASE_DSN,
+// migrates it and empties the metric tables. The database must be a
+// disposable one.
+func newTestPostgresStorage(t *testing.T) *PostgresStorage {
+	return newTestPostgresStorageWithHistory(t, 0)
+}
+
+func newTestPostgresStorageWithHistory(t *testing.T, historySize int) *PostgresStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	m, err := migrate.New("file://migrations/postgres", dsn)
+	require.NoError(t, err)
+	if err = m.Up(); err != migrate.ErrNoChange {
+		require.NoError(t, err)
+	}
+	m.Close()
+
+	ctx := context.Background()
+	store, err := NewPostgresStorage(ctx, &types.ServerConfig{DBDsn: dsn, HistorySize: historySize})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+	_, err = store.Pool.Exec(ctx, "TRUNCATE metrics, metric_samples RESTART IDENTITY")
+	require.NoError(t, err)
+	return store
+}
+
+func TestPostgresStorageHistory(t *testing.T) {
+	testRepositoryHistory(t, func(t *testing.T, historySize int) Repository {
+		return newTestPostgresStorageWithHistory(t, historySize)
+	})
+}
+
+func TestPostgresStorageInsertMetrics(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	count := func(v uint64) *uint64 { return &v }
+	tests := []struct {
+		name    string
+		batches [][]types.Metric
+		wantErr bool
+		want    []types.Metric
+	}{
+		{
+			name: "adds counters across batches",
+			batches: [][]types.Metric{
+				{{ID: "PollCount", MType: "counter", Delta: delta(1)}},
+				{{ID: "PollCount", MType: "counter", Delta: delta(2)}},
+			},
+			want: []types.Metric{
+				{ID: "PollCount", MType: "counter", Delta: delta(3)},
+			},
+		},
+		{
+			name: "merges series repeated in a batch",
+			batches: [][]types.Metric{
+				{
+					{ID: "PollCount", MType: "counter", Delta: delta(1)},
+					{ID: "Alloc", MType: "gauge", Value: value(1)},
+					{ID: "PollCount", MType: "counter", Delta: delta(2)},
+					{ID: "Alloc", MType: "gauge", Value: value(2)},
+				},
+			},
+			want: []types.Metric{
+				{ID: "PollCount", MType: "counter", Delta: delta(3)},
+				{ID: "Alloc", MType: "gauge", Value: value(2)},
+			},
+		},
+		{
+			name: "keeps labeled series apart",
+			batches: [][]types.Metric{
+				{
+					{ID: "DiskFree", MType: "gauge", Value: value(1), Labels: map[string]string{"mountpoint": "/"}},
+					{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/home"}},
+				},
+			},
+			want: []types.Metric{
+				{ID: "DiskFree", MType: "gauge", Value: value(1), Labels: map[string]string{"mountpoint": "/"}},
+				{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"mountpoint": "/home"}},
+			},
+		},
+		{
+			name: "merges histograms and replaces summaries",
+			batches: [][]types.Metric{
+				{
+					{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+					{ID: "Pause", MType: "summary", Sum: value(1), Count: count(1), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1}}},
+				},
+				{
+					{ID: "Latency", MType: "histogram", Sum: value(5), Count: count(2), Buckets: []types.Bucket{{UpperBound: 1, Count: 0}}},
+					{ID: "Pause", MType: "summary", Sum: value(3), Count: count(2), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 2}}},
+				},
+			},
+			want: []types.Metric{
+				{ID: "Latency", MType: "histogram", Sum: value(6), Count: count(3), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+				{ID: "Pause", MType: "summary", Sum: value(3), Count: count(2), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 2}}},
+			},
+		},
+		{
+			name: "stores nothing of a failed batch",
+			batches: [][]types.Metric{
+				{
+					{ID: "Alloc", MType: "gauge", Value: value(1)},
+					{ID: strings.Repeat("x", 300), MType: "gauge", Value: value(1)},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestPostgresStorage(t)
+			var err error
+			for _, batch := range tt.batches {
+				if err = store.InsertMetrics(ctx, batch); err != nil {
+					break
+				}
+			}
+			if tt.wantErr {
+				var batchErr *BatchError
+				require.ErrorAs(t, err, &batchErr)
+				assert.Equal(t, strings.Repeat("x", 300), batchErr.ID)
+			} else {
+				require.NoError(t, err)
+			}
+
+			metrics, err := store.AsMetrics(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, metrics.Metric)
+		})
+	}
+}
+
+func TestPostgresStorageGetters(t *testing.T) {
+	ctx := context.Background()
+	store := newTestPostgresStorage(t)
+	value := 1.5
+	sum, count := 1.0, uint64(1)
+	require.NoError(t, store.AddCounter(ctx, "PollCount", 2))
+	require.NoError(t, store.AddCounter(ctx, "PollCount", 3))
+	require.NoError(t, store.AddGauge(ctx, "Alloc", 1.5))
+	require.NoError(t, store.InsertMetrics(ctx, []types.Metric{
+		{ID: "Latency", MType: "histogram", Sum: &sum, Count: &count},
+		{ID: "DiskFree", MType: "gauge", Value: &value, Labels: map[string]string{"mountpoint": "/"}},
+	}))
+
+	tests := []struct {
+		name    string
+		mType   string
+		key     string
+		want    string
+		wantErr error
+	}{
+		{name: "counter", mType: "counter", key: "PollCount", want: "5"},
+		{name: "gauge", mType: "gauge", key: "Alloc", want: "1.500"},
+		{name: "gauge of another type", mType: "gauge", key: "PollCount", wantErr: ErrNotFound},
+		{name: "distribution has no scalar value", mType: "histogram", key: "Latency", wantErr: ErrNotFound},
+		{name: "labeled series needs labels", mType: "gauge", key: "DiskFree", wantErr: ErrNotFound},
+		{name: "missing", mType: "counter", key: "Missing", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.GetMetric(ctx, tt.mType, tt.key)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	all, err := store.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "PollCount = 5 \nAlloc = 1.5 \nDiskFree{mountpoint=\"/\"} = 1.5 \nLatency count = 1 sum = 1 \n", all)
+
+	found, err := store.FindMetrics(ctx, "gauge", "DiskFree", map[string]string{"mountpoint": "/"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, 1.5, *found[0].Value)
+
+	samples, err := store.GetSamples(ctx, "counter", "PollCount", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 5.0, samples[1].Value)
+	_, err = store.GetSamples(ctx, "counter", "Missing", nil, time.Now().Add(-time.Hour), time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
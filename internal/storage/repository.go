@@ -1,21 +1,116 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/yurchenkosv/metric-service/internal/types"
+	"sort"
+	"time"
 )
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound        = errors.New("not found")
+	ErrAmbiguousSeries = errors.New("labels match more than one series")
+	// ErrInvalidMetric is returned for a metric without the value its type
+	// requires.
+	ErrInvalidMetric = errors.New("metric has no value")
 )
 
+// BatchError is returned by InsertMetrics when a metric of the batch could
+// not be stored; none of the batch is stored then.
+type BatchError struct {
+	ID     string
+	Labels map[string]string
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch rejected, no metrics stored: metric %s: %v", types.SeriesKey(e.ID, e.Labels), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// checkValue fails with ErrInvalidMetric if metric lacks the fields its type
+// is stored with.
+func checkValue(metric types.Metric) error {
+	switch {
+	case metric.MType == "counter" && metric.Delta == nil,
+		metric.MType == "gauge" && metric.Value == nil,
+		(metric.MType == "histogram" || metric.MType == "summary") && (metric.Sum == nil || metric.Count == nil):
+		err := fmt.Errorf("%w: %s %s", ErrInvalidMetric, metric.MType, types.SeriesKey(metric.ID, metric.Labels))
+		return &BatchError{ID: metric.ID, Labels: metric.Labels, Err: err}
+	}
+	return nil
+}
+
+// batchKey identifies a stored series: series of different types never
+// collide, even with the same name and labels.
+func batchKey(metric types.Metric) string {
+	return metric.MType + " " + types.SeriesKey(metric.ID, metric.Labels)
+}
+
+// aggregateBatch merges metrics of the same series: counter and histogram
+// deltas are summed, the last gauge or summary wins. Series are sorted so
+// concurrent batches lock rows in the same order.
+func aggregateBatch(metrics []types.Metric) ([]types.Metric, error) {
+	pending := make(map[string]types.Metric, len(metrics))
+	for _, metric := range metrics {
+		if err := checkValue(metric); err != nil {
+			return nil, err
+		}
+		key := batchKey(metric)
+		if stored, ok := pending[key]; ok {
+			metric = types.AccumulateMetric(stored, metric)
+		}
+		pending[key] = metric
+	}
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	aggregated := make([]types.Metric, 0, len(keys))
+	for _, key := range keys {
+		aggregated = append(aggregated, pending[key])
+	}
+	return aggregated, nil
+}
+
 type Repository interface {
-	AddCounter(string, types.Counter)
-	AddGauge(string, types.Gauge)
-	GetMetricByKey(string) (string, error)
-	GetCounterByKey(string) (types.Counter, error)
-	GetGaugeByKey(string) (types.Gauge, error)
-	GetAllMetrics() string
-	AsMetrics() types.Metrics
-	InsertMetrics([]types.Metric)
+	AddCounter(context.Context, string, types.Counter) error
+	AddGauge(context.Context, string, types.Gauge) error
+	// GetMetric returns the value of the counter or gauge mType named name
+	// without labels, formatted for the text API.
+	GetMetric(ctx context.Context, mType string, name string) (string, error)
+	GetCounterByKey(context.Context, string) (types.Counter, error)
+	GetGaugeByKey(context.Context, string) (types.Gauge, error)
+	GetAllMetrics(context.Context) (string, error)
+	AsMetrics(context.Context) (types.Metrics, error)
+	InsertMetrics(context.Context, []types.Metric) error
+	// FindMetrics returns every series of the given type and name whose
+	// labels contain all of the matchers.
+	FindMetrics(ctx context.Context, mType string, name string, matchers map[string]string) ([]types.Metric, error)
+	// GetSamples returns recorded samples of a series taken between from and to
+	// in chronological order, or ErrNotFound if the series does not exist.
+	GetSamples(ctx context.Context, mType string, name string, labels map[string]string, from time.Time, to time.Time) ([]types.Sample, error)
+}
+
+// SelectSeries picks the series whose labels equal the matchers, or the only
+// series matched by them.
+func SelectSeries(metrics []types.Metric, matchers map[string]string) (types.Metric, error) {
+	for _, metric := range metrics {
+		if len(metric.Labels) == len(matchers) && types.MatchLabels(metric.Labels, matchers) {
+			return metric, nil
+		}
+	}
+	if len(metrics) == 0 {
+		return types.Metric{}, ErrNotFound
+	}
+	if len(metrics) > 1 {
+		return types.Metric{}, ErrAmbiguousSeries
+	}
+	return metrics[0], nil
 }
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// SQLite statements share names with the Postgres ones. Labels are stored as
+// JSON text encoded with sorted keys, so equal label sets compare equal.
+var sqliteStatements = map[string]string{
+	stmtGetCounter: `
+		SELECT metric_delta
+		FROM metrics
+		WHERE metric_type='counter' AND metric_id=? AND labels='{}'`,
+	stmtGetGauge: `
+		SELECT metric_value
+		FROM metrics
+		WHERE metric_type='gauge' AND metric_id=? AND labels='{}'`,
+	stmtAllMetrics: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		ORDER BY CASE metric_type WHEN 'counter' THEN 0 WHEN 'gauge' THEN 1 ELSE 2 END, id`,
+	stmtUpsertScalar: `
+		INSERT INTO metrics(metric_id, metric_type, metric_delta, metric_value, hash, labels)
+		VALUES(?1, ?2, ?3, ?4, ?5, ?6)
+		ON CONFLICT (metric_type, metric_id, labels) DO UPDATE
+		SET metric_delta=metric_delta+?3,
+			metric_value=?4,
+			hash=?5`,
+	stmtUpsertDistribution: `
+		INSERT INTO metrics(metric_id, metric_type, metric_sum, metric_count, buckets, quantiles, hash, labels)
+		VALUES(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT (metric_type, metric_id, labels) DO UPDATE
+		SET metric_sum=?3,
+			metric_count=?4,
+			buckets=?5,
+			quantiles=?6,
+			hash=?7`,
+	stmtLockDistribution: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		WHERE metric_type=? AND metric_id=? AND labels=?`,
+	stmtFindMetrics: `
+		SELECT ` + metricColumns + `
+		FROM metrics
+		WHERE metric_type=? AND metric_id=?`,
+	stmtGetSamples: `
+		SELECT created_at, value
+		FROM metric_samples
+		WHERE metric_type=? AND metric_id=? AND labels=?
+			AND created_at BETWEEN ? AND ?
+		ORDER BY created_at, id`,
+	stmtSeriesExists: `
+		SELECT EXISTS(SELECT 1 FROM metrics WHERE metric_type=? AND metric_id=? AND labels=?)`,
+	stmtTrimSamples: `
+		DELETE FROM metric_samples
+		WHERE metric_type=?1 AND metric_id=?2 AND labels=?3
+			AND id <= (
+				SELECT id FROM metric_samples
+				WHERE metric_type=?1 AND metric_id=?2 AND labels=?3
+				ORDER BY id DESC
+				LIMIT 1 OFFSET ?4)`,
+}
+
+// prepareSQLiteStatements prepares every statement on db.
+func prepareSQLiteStatements(ctx context.Context, db *sql.DB) (map[string]*sql.Stmt, error) {
+	prepared := make(map[string]*sql.Stmt, len(sqliteStatements))
+	for name, query := range sqliteStatements {
+		stmt, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			closeStatements(prepared)
+			return nil, fmt.Errorf("prepare %s: %w", name, err)
+		}
+		prepared[name] = stmt
+	}
+	return prepared, nil
+}
+
+func closeStatements(prepared map[string]*sql.Stmt) {
+	for _, stmt := range prepared {
+		stmt.Close()
+	}
+}
+
+// scanSQLiteMetric reads a row selected with metricColumns. JSON columns are
+// stored as text and decoded here.
+func scanSQLiteMetric(row interface{ Scan(...interface{}) error }) (types.Metric, error) {
+	var metric types.Metric
+	var buckets, quantiles, hash sql.NullString
+	var labels string
+	err := row.Scan(
+		&metric.ID,
+		&metric.MType,
+		&metric.Delta,
+		&metric.Value,
+		&metric.Sum,
+		&metric.Count,
+		&buckets,
+		&quantiles,
+		&hash,
+		&labels,
+	)
+	if err != nil {
+		return metric, err
+	}
+	if buckets.Valid {
+		if err = json.Unmarshal([]byte(buckets.String), &metric.Buckets); err != nil {
+			return metric, err
+		}
+	}
+	if quantiles.Valid {
+		if err = json.Unmarshal([]byte(quantiles.String), &metric.Quantiles); err != nil {
+			return metric, err
+		}
+	}
+	if err = json.Unmarshal([]byte(labels), &metric.Labels); err != nil {
+		return metric, err
+	}
+	if len(metric.Labels) == 0 {
+		metric.Labels = nil
+	}
+	metric.Hash = hash.String
+	return metric, nil
+}
+
+// labelsText encodes labels the way they are stored in SQLite.
+func labelsText(labels map[string]string) (string, error) {
+	encoded, err := json.Marshal(labelsOrEmpty(labels))
+	return string(encoded), err
+}
+
+// jsonText encodes a JSON column value, or NULL if v is empty.
+func jsonText(v interface{}, empty bool) (interface{}, error) {
+	if empty {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yurchenkosv/metric-service/internal/types"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme prefixes the storage setting of SQLite databases, as in
+// sqlite:///var/lib/metrics.db.
+const SQLiteScheme = "sqlite://"
+
+// SQLiteStorage keeps metrics in a single SQLite file. It uses one
+// connection, so transactions never wait on each other for the file lock.
+type SQLiteStorage struct {
+	DB    *sql.DB
+	stmts map[string]*sql.Stmt
+	// historySize is the number of samples kept per series.
+	historySize int
+}
+
+// SQLitePath returns the database file of a sqlite:// storage setting.
+func SQLitePath(storage string) (string, error) {
+	if !strings.HasPrefix(storage, SQLiteScheme) {
+		return "", fmt.Errorf("storage %q is not a %s URL", storage, SQLiteScheme)
+	}
+	path := strings.TrimPrefix(storage, SQLiteScheme)
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "", fmt.Errorf("storage %q has no database path", storage)
+	}
+	return path, nil
+}
+
+func NewSQLiteStorage(ctx context.Context, cfg *types.ServerConfig) (*SQLiteStorage, error) {
+	path, err := SQLitePath(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err = db.ExecContext(ctx, "PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	stmts, err := prepareSQLiteStatements(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{DB: db, stmts: stmts, historySize: historySize(cfg)}, nil
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *SQLiteStorage) Close() {
+	closeStatements(s.stmts)
+	s.DB.Close()
+}
+
+func (s *SQLiteStorage) AddCounter(ctx context.Context, name string, counter types.Counter) error {
+	delta := int64(counter)
+	return s.InsertMetrics(ctx, []types.Metric{{ID: name, MType: "counter", Delta: &delta}})
+}
+
+func (s *SQLiteStorage) AddGauge(ctx context.Context, name string, gauge types.Gauge) error {
+	value := float64(gauge)
+	return s.InsertMetrics(ctx, []types.Metric{{ID: name, MType: "gauge", Value: &value}})
+}
+
+func (s *SQLiteStorage) GetMetric(ctx context.Context, mType string, name string) (string, error) {
+	switch mType {
+	case "counter":
+		counter, err := s.GetCounterByKey(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", counter), nil
+	case "gauge":
+		gauge, err := s.GetGaugeByKey(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%.3f", gauge), nil
+	}
+	return "", ErrNotFound
+}
+
+func (s *SQLiteStorage) GetCounterByKey(ctx context.Context, name string) (types.Counter, error) {
+	var counter *int64
+	err := s.stmts[stmtGetCounter].QueryRowContext(ctx, name).Scan(&counter)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && counter == nil) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return types.Counter(*counter), nil
+}
+
+func (s *SQLiteStorage) GetGaugeByKey(ctx context.Context, name string) (types.Gauge, error) {
+	var gauge *float64
+	err := s.stmts[stmtGetGauge].QueryRowContext(ctx, name).Scan(&gauge)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && gauge == nil) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return types.Gauge(*gauge), nil
+}
+
+func (s *SQLiteStorage) GetAllMetrics(ctx context.Context) (string, error) {
+	metrics, err := s.AsMetrics(ctx)
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	for _, metric := range metrics.Metric {
+		key := types.SeriesKey(metric.ID, metric.Labels)
+		switch {
+		case metric.MType == "counter" && metric.Delta != nil:
+			fmt.Fprintf(&builder, "%s = %d \n", key, *metric.Delta)
+		case metric.MType == "gauge" && metric.Value != nil:
+			fmt.Fprintf(&builder, "%s = %v \n", key, *metric.Value)
+		case metric.Sum != nil && metric.Count != nil:
+			fmt.Fprintf(&builder, "%s count = %d sum = %v \n", key, *metric.Count, *metric.Sum)
+		}
+	}
+	return builder.String(), nil
+}
+
+func (s *SQLiteStorage) AsMetrics(ctx context.Context) (types.Metrics, error) {
+	var metrics types.Metrics
+	result, err := s.stmts[stmtAllMetrics].QueryContext(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		metric, err := scanSQLiteMetric(result)
+		if err != nil {
+			return metrics, err
+		}
+		metrics.Metric = append(metrics.Metric, metric)
+	}
+	return metrics, result.Err()
+}
+
+// InsertMetrics stores the batch in one transaction with the same guarantees
+// as PostgresStorage.InsertMetrics. SQLite runs one write transaction at a
+// time, so no row locks are needed to merge distributions.
+func (s *SQLiteStorage) InsertMetrics(ctx context.Context, metrics []types.Metric) error {
+	metrics, err := aggregateBatch(metrics)
+	if err != nil || len(metrics) == 0 {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, metric := range metrics {
+		if err = s.insertMetric(ctx, tx, metric); err != nil {
+			return &BatchError{ID: metric.ID, Labels: metric.Labels, Err: err}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) insertMetric(ctx context.Context, tx *sql.Tx, metric types.Metric) error {
+	labels, err := labelsText(metric.Labels)
+	if err != nil {
+		return err
+	}
+	if metric.MType != "histogram" && metric.MType != "summary" {
+		_, err = tx.StmtContext(ctx, s.stmts[stmtUpsertScalar]).ExecContext(ctx,
+			metric.ID,
+			metric.MType,
+			metric.Delta,
+			metric.Value,
+			nullString(metric.Hash),
+			labels,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.StmtContext(ctx, s.stmts[stmtTrimSamples]).ExecContext(ctx, metric.MType, metric.ID, labels, s.historySize)
+		return err
+	}
+
+	var previous *types.Metric
+	row, err := scanSQLiteMetric(tx.StmtContext(ctx, s.stmts[stmtLockDistribution]).QueryRowContext(ctx, metric.MType, metric.ID, labels))
+	if err == nil {
+		previous = &row
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	merged := types.MergeDistribution(previous, metric)
+	buckets, err := jsonText(merged.Buckets, len(merged.Buckets) == 0)
+	if err != nil {
+		return err
+	}
+	quantiles, err := jsonText(merged.Quantiles, len(merged.Quantiles) == 0)
+	if err != nil {
+		return err
+	}
+	_, err = tx.StmtContext(ctx, s.stmts[stmtUpsertDistribution]).ExecContext(ctx,
+		merged.ID,
+		merged.MType,
+		merged.Sum,
+		merged.Count,
+		buckets,
+		quantiles,
+		nullString(metric.Hash),
+		labels,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) FindMetrics(ctx context.Context, mType string, name string, matchers map[string]string) ([]types.Metric, error) {
+	var metrics []types.Metric
+	result, err := s.stmts[stmtFindMetrics].QueryContext(ctx, mType, name)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		metric, err := scanSQLiteMetric(result)
+		if err != nil {
+			return nil, err
+		}
+		if !types.MatchLabels(metric.Labels, matchers) {
+			continue
+		}
+		metric.Hash = ""
+		metrics = append(metrics, metric)
+	}
+	return metrics, result.Err()
+}
+
+func (s *SQLiteStorage) GetSamples(ctx context.Context, mType string, name string, labels map[string]string, from time.Time, to time.Time) ([]types.Sample, error) {
+	key, err := labelsText(labels)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := s.querySamples(ctx, mType, name, key, from, to)
+	if err != nil || len(samples) > 0 {
+		return samples, err
+	}
+
+	var exists bool
+	err = s.stmts[stmtSeriesExists].QueryRowContext(ctx, mType, name, key).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return samples, nil
+}
+
+// querySamples reads samples of a series; created_at is unix time in
+// milliseconds.
+func (s *SQLiteStorage) querySamples(ctx context.Context, mType string, name string, labels string, from time.Time, to time.Time) ([]types.Sample, error) {
+	var samples []types.Sample
+	result, err := s.stmts[stmtGetSamples].QueryContext(ctx, mType, name, labels,
+		from.UnixNano()/int64(time.Millisecond), to.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var sample types.Sample
+		var createdAt int64
+		if err = result.Scan(&createdAt, &sample.Value); err != nil {
+			return nil, err
+		}
+		sample.Timestamp = time.Unix(0, createdAt*int64(time.Millisecond))
+		samples = append(samples, sample)
+	}
+	return samples, result.Err()
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yurchenkosv/metric-service/internal/types"
+)
+
+// openTestSQLiteStorage migrates and opens the database in path.
+func openTestSQLiteStorage(t *testing.T, path string, historySize int) *SQLiteStorage {
+	m, err := migrate.New("file://migrations/sqlite", SQLiteScheme+path)
+	require.NoError(t, err)
+	if err = m.Up(); err != migrate.ErrNoChange {
+		require.NoError(t, err)
+	}
+	m.Close()
+
+	store, err := NewSQLiteStorage(context.Background(), &types.ServerConfig{Storage: SQLiteScheme + path, HistorySize: historySize})
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+	return store
+}
+
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	return openTestSQLiteStorage(t, filepath.Join(t.TempDir(), "metrics.db"), 0)
+}
+
+func TestSQLitePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    string
+		wantErr bool
+	}{
+		{name: "absolute path", storage: "sqlite:///var/lib/metrics.db", want: "/var/lib/metrics.db"},
+		{name: "relative path", storage: "sqlite://metrics.db", want: "metrics.db"},
+		{name: "query is dropped", storage: "sqlite:///metrics.db?x-no-tx-wrap=true", want: "/metrics.db"},
+		{name: "no path", storage: "sqlite://", wantErr: true},
+		{name: "other scheme", storage: "postgres://localhost/metrics", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := SQLitePath(tt.storage)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, path)
+		})
+	}
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return newTestSQLiteStorage(t)
+	})
+}
+
+func TestSQLiteStorageHistory(t *testing.T) {
+	testRepositoryHistory(t, func(t *testing.T, historySize int) Repository {
+		return openTestSQLiteStorage(t, filepath.Join(t.TempDir(), "metrics.db"), historySize)
+	})
+}
+
+func TestSQLiteStorageInsertMetrics(t *testing.T) {
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+	count := func(v uint64) *uint64 { return &v }
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	store := openTestSQLiteStorage(t, path, 0)
+
+	batches := [][]types.Metric{
+		{
+			{ID: "PollCount", MType: "counter", Delta: delta(1)},
+			{ID: "Latency", MType: "histogram", Sum: value(1), Count: count(1), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+			{ID: "Pause", MType: "summary", Sum: value(1), Count: count(1), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 1}}},
+			{ID: "DiskFree", MType: "gauge", Value: value(1), Labels: map[string]string{"mountpoint": "/", "device": "sda1"}},
+		},
+		{
+			{ID: "PollCount", MType: "counter", Delta: delta(2)},
+			{ID: "Latency", MType: "histogram", Sum: value(5), Count: count(2), Buckets: []types.Bucket{{UpperBound: 1, Count: 0}}},
+			{ID: "Pause", MType: "summary", Sum: value(3), Count: count(2), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 2}}},
+			{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"device": "sda1", "mountpoint": "/"}},
+		},
+	}
+	for _, batch := range batches {
+		require.NoError(t, store.InsertMetrics(ctx, batch))
+	}
+	want := []types.Metric{
+		{ID: "PollCount", MType: "counter", Delta: delta(3)},
+		{ID: "DiskFree", MType: "gauge", Value: value(2), Labels: map[string]string{"device": "sda1", "mountpoint": "/"}},
+		{ID: "Latency", MType: "histogram", Sum: value(6), Count: count(3), Buckets: []types.Bucket{{UpperBound: 1, Count: 1}}},
+		{ID: "Pause", MType: "summary", Sum: value(3), Count: count(2), Quantiles: []types.Quantile{{Quantile: 0.5, Value: 2}}},
+	}
+	metrics, err := store.AsMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, metrics.Metric)
+
+	samples, err := store.GetSamples(ctx, "gauge", "DiskFree", map[string]string{"mountpoint": "/", "device": "sda1"},
+		time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	var values []float64
+	for _, sample := range samples {
+		values = append(values, sample.Value)
+	}
+	assert.Equal(t, []float64{1, 2}, values)
+
+	store.Close()
+	reopened := openTestSQLiteStorage(t, path, 0)
+	metrics, err = reopened.AsMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, metrics.Metric, "metrics survive a restart")
+}
+
+func TestSQLiteMigrateTypeIdentity(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	m, err := migrate.New("file://migrations/sqlite", SQLiteScheme+path)
+	require.NoError(t, err)
+	require.NoError(t, m.Migrate(2))
+	m.Close()
+
+	store, err := NewSQLiteStorage(ctx, &types.ServerConfig{Storage: SQLiteScheme + path})
+	require.NoError(t, err)
+	_, err = store.DB.ExecContext(ctx, `INSERT INTO metrics(metric_id, metric_type, metric_delta) VALUES('PollCount', 'counter', 1)`)
+	require.NoError(t, err)
+	store.Close()
+
+	store = openTestSQLiteStorage(t, path, 0)
+	require.NoError(t, store.AddGauge(ctx, "PollCount", 2))
+	require.NoError(t, store.AddCounter(ctx, "PollCount", 1))
+
+	counter, err := store.GetCounterByKey(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(2), counter)
+	gauge, err := store.GetGaugeByKey(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(2), gauge)
+	samples, err := store.GetSamples(ctx, "counter", "PollCount", nil, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, samples, 2, "samples are still recorded after the table is rebuilt")
+}
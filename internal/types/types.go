@@ -5,6 +5,9 @@ import (
 	"github.com/caarlos0/env/v6"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,18 +19,197 @@ type Metrics struct {
 }
 
 type Metric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
+	ID        string            `json:"id"`                  // имя метрики
+	MType     string            `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64            `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64          `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Sum       *float64          `json:"sum,omitempty"`       // сумма наблюдений histogram или summary
+	Count     *uint64           `json:"count,omitempty"`     // количество наблюдений histogram или summary
+	Buckets   []Bucket          `json:"buckets,omitempty"`   // корзины histogram
+	Quantiles []Quantile        `json:"quantiles,omitempty"` // квантили summary
+	Hash      string            `json:"hash,omitempty"`      // значение хеш-функции
+	Labels    map[string]string `json:"labels,omitempty"`    // метки, вместе с ID определяющие серию
+}
+
+// Bucket is a cumulative histogram bucket: Count observations were less than
+// or equal to UpperBound. The +Inf bucket is implied by Metric.Count.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// ValidLabelName reports whether name may be used as a label name.
+// Label names follow the Prometheus rules: [a-zA-Z_][a-zA-Z0-9_]*.
+func ValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			continue
+		}
+		if i > 0 && r >= '0' && r <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// FormatLabels renders labels as comma separated key="value" pairs sorted by key.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+strconv.Quote(labels[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// SeriesKey identifies a series by metric name and labels.
+// Series without labels are identified by the name alone.
+func SeriesKey(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+	return id + "{" + FormatLabels(labels) + "}"
+}
+
+// MatchLabels reports whether labels contain every matcher.
+func MatchLabels(labels map[string]string, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if val, ok := labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AccumulateMetric merges metric into stored, a metric of the same series
+// seen earlier: counter and histogram deltas are added up, snapshots are
+// replaced by the later one.
+func AccumulateMetric(stored Metric, metric Metric) Metric {
+	switch {
+	case metric.MType == "counter" && stored.Delta != nil && metric.Delta != nil:
+		delta := *stored.Delta + *metric.Delta
+		metric.Delta = &delta
+	case metric.MType == "histogram" && stored.Sum != nil && stored.Count != nil &&
+		metric.Sum != nil && metric.Count != nil:
+		merged := MergeDistribution(&stored, metric)
+		merged.Hash = metric.Hash
+		return merged
+	}
+	return metric
+}
+
+// SameBuckets reports whether two histograms have the same bucket bounds.
+func SameBuckets(a []Bucket, b []Bucket) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UpperBound != b[i].UpperBound {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeDistribution combines a reported histogram or summary with the stored
+// one. Histograms are reported as deltas, so buckets, sum and count are summed
+// up; a histogram with different bucket boundaries starts over. Summaries
+// replace the stored value, since quantiles can not be combined.
+func MergeDistribution(stored *Metric, metric Metric) Metric {
+	merged := CopyDistribution(metric)
+	if metric.MType != "histogram" || stored == nil || !SameBuckets(stored.Buckets, metric.Buckets) {
+		return merged
+	}
+	for i := range merged.Buckets {
+		merged.Buckets[i].Count += stored.Buckets[i].Count
+	}
+	sum := *stored.Sum + *merged.Sum
+	count := *stored.Count + *merged.Count
+	merged.Sum = &sum
+	merged.Count = &count
+	return merged
+}
+
+// CopyDistribution returns a copy of a histogram or summary that shares no
+// memory with metric. The hash is not copied.
+func CopyDistribution(metric Metric) Metric {
+	copied := Metric{
+		ID:    metric.ID,
+		MType: metric.MType,
+	}
+	var sum float64
+	var count uint64
+	if metric.Sum != nil {
+		sum = *metric.Sum
+	}
+	if metric.Count != nil {
+		count = *metric.Count
+	}
+	copied.Sum = &sum
+	copied.Count = &count
+	if len(metric.Buckets) > 0 {
+		copied.Buckets = append([]Bucket(nil), metric.Buckets...)
+	}
+	if len(metric.Quantiles) > 0 {
+		copied.Quantiles = append([]Quantile(nil), metric.Quantiles...)
+	}
+	if len(metric.Labels) > 0 {
+		copied.Labels = make(map[string]string, len(metric.Labels))
+		for k, v := range metric.Labels {
+			copied.Labels[k] = v
+		}
+	}
+	return copied
+}
+
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+type SeriesRange struct {
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []Sample          `json:"points"`
 }
 
 type AgentConfig struct {
-	Address        string        `env:"ADDRESS"`
-	ReportInterval time.Duration `env:"REPORT_INTERVAL"`
-	PollInterval   time.Duration `env:"POLL_INTERVAL"`
-	Key            string        `env:"KEY"`
+	Address            string        `env:"ADDRESS"`
+	ReportInterval     time.Duration `env:"REPORT_INTERVAL"`
+	PollInterval       time.Duration `env:"POLL_INTERVAL"`
+	SystemInterval     time.Duration `env:"SYSTEM_POLL_INTERVAL"`
+	Collectors         string        `env:"COLLECTORS"`
+	DisabledCollectors string        `env:"DISABLE_COLLECTORS"`
+	Key                string        `env:"KEY"`
+	Labels             string        `env:"LABELS"`
+	SignRequests       bool          `env:"SIGN_REQUESTS"`
+	CryptoKey          string        `env:"CRYPTO_KEY"`
+	Scheme             string        `env:"SCHEME"`
+	Transport          string        `env:"TRANSPORT"`
+	TLSCA              string        `env:"TLS_CA"`
+	TLSCert            string        `env:"TLS_CERT"`
+	TLSKey             string        `env:"TLS_KEY"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	RateLimit          int           `env:"RATE_LIMIT"`
+	QueueSize          int           `env:"QUEUE_SIZE"`
+	OutboxDir          string        `env:"OUTBOX_DIR"`
+	OutboxMaxSize      int64         `env:"OUTBOX_MAX_SIZE"`
+	OutboxMaxAge       time.Duration `env:"OUTBOX_MAX_AGE"`
 }
 
 type ServerConfig struct {
@@ -37,13 +219,53 @@ type ServerConfig struct {
 	Restore       bool          `env:"RESTORE"`
 	Key           string        `env:"KEY"`
 	DBDsn         string        `env:"DATABASE_DSN"`
+	Storage       string        `env:"STORAGE"`
+
+	RequireSignature bool          `env:"REQUIRE_SIGNATURE"`
+	SignatureWindow  time.Duration `env:"SIGNATURE_WINDOW"`
+	CryptoKey        string        `env:"CRYPTO_KEY"`
+
+	TLSCert     string `env:"TLS_CERT"`
+	TLSKey      string `env:"TLS_KEY"`
+	TLSClientCA string `env:"TLS_CLIENT_CA"`
+
+	GRPCAddress string `env:"GRPC_ADDRESS"`
+
+	TrustedSubnet string `env:"TRUSTED_SUBNET"`
+
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+
+	PrometheusLabels string `env:"PROMETHEUS_LABELS"`
+	HistorySize      int    `env:"HISTORY_SIZE"`
+
+	DBMaxConns          int           `env:"DATABASE_MAX_CONNS"`
+	DBMinConns          int           `env:"DATABASE_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `env:"DATABASE_MAX_CONN_LIFETIME"`
+	DBHealthCheckPeriod time.Duration `env:"DATABASE_HEALTH_CHECK_PERIOD"`
 }
 
 func (c *AgentConfig) Parse() error {
 	flag.StringVar(&c.Address, "a", "localhost:8080", "http address to send metrics in format localhost:8080")
 	flag.DurationVar(&c.ReportInterval, "r", 10*time.Second, "interval to send metrics to server. Inactive for server.")
 	flag.DurationVar(&c.PollInterval, "p", 2*time.Second, "Interval to collect metrics. Inactive for server.")
+	flag.DurationVar(&c.SystemInterval, "system-poll-interval", 2*time.Second, "interval to collect host metrics: memory, CPU, load, disks and network")
+	flag.StringVar(&c.Collectors, "collectors", "", "comma-separated collectors to enable: runtime, random, system; all if empty")
+	flag.StringVar(&c.DisabledCollectors, "disable-collectors", "", "comma-separated collectors to disable")
 	flag.StringVar(&c.Key, "k", "", "key to create hash")
+	flag.BoolVar(&c.SignRequests, "sign-requests", false, "sign whole request body with key, timestamp and nonce")
+	flag.StringVar(&c.CryptoKey, "crypto-key", "", "path to server RSA public key in PEM format; enables payload encryption")
+	flag.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for the last report on shutdown")
+	flag.IntVar(&c.RateLimit, "rate-limit", 1, "maximum number of concurrent requests to server")
+	flag.IntVar(&c.QueueSize, "queue-size", 10, "number of reports waiting to be sent before new ones are dropped")
+	flag.StringVar(&c.OutboxDir, "outbox-dir", "", "directory to keep reports in while server is unavailable; disabled if empty")
+	flag.Int64Var(&c.OutboxMaxSize, "outbox-max-size", 10<<20, "maximum outbox size in bytes; oldest reports are dropped beyond it")
+	flag.DurationVar(&c.OutboxMaxAge, "outbox-max-age", time.Hour, "how long to keep reports in outbox")
+	flag.StringVar(&c.Transport, "transport", "http", "protocol to push metrics with: http or grpc; for grpc -a is the server gRPC address and -crypto-key and -sign-requests are not supported")
+	flag.StringVar(&c.Scheme, "scheme", "http", "scheme to push metrics with: http or https")
+	flag.StringVar(&c.TLSCA, "tls-ca", "", "path to CA bundle used to verify server certificate; system roots if empty")
+	flag.StringVar(&c.TLSCert, "tls-cert", "", "path to client certificate presented to server")
+	flag.StringVar(&c.TLSKey, "tls-key", "", "path to client certificate key")
+	flag.StringVar(&c.Labels, "l", "", "labels attached to every metric in format key=value,key=value")
 	flag.Parse()
 
 	err := env.Parse(c)
@@ -57,10 +279,26 @@ func (c *ServerConfig) Parse() error {
 	flag.BoolVar(&c.Restore, "r", true, "If set to true, read file in -f flag to restore metrics state")
 	flag.StringVar(&c.Key, "k", "", "key to create/validate hash")
 	flag.StringVar(&c.DBDsn, "d", "", "Postgres connection string")
+	flag.StringVar(&c.Storage, "storage", "", "database to store metrics in, e.g. sqlite:///var/lib/metrics.db; in memory with -f snapshots if empty and -d is not set")
+	flag.BoolVar(&c.RequireSignature, "require-signature", false, "reject update requests without request signature. Requires key.")
+	flag.DurationVar(&c.SignatureWindow, "signature-window", 5*time.Minute, "how far request signature timestamp may drift from server time; nonces are remembered for that long")
+	flag.StringVar(&c.CryptoKey, "crypto-key", "", "path to RSA private key in PEM format to decrypt agent payloads")
+	flag.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	flag.StringVar(&c.TrustedSubnet, "t", "", "CIDR of agents allowed to push metrics, checked against X-Real-IP; any if empty")
+	flag.StringVar(&c.GRPCAddress, "grpc-address", "", "address to serve gRPC API on, e.g. localhost:3200; disabled if empty, can not be used with -require-signature")
+	flag.StringVar(&c.TLSCert, "tls-cert", "", "path to server certificate; enables https")
+	flag.StringVar(&c.TLSKey, "tls-key", "", "path to server certificate key")
+	flag.StringVar(&c.TLSClientCA, "tls-client-ca", "", "path to CA bundle of enrolled agents; update requests must present a client certificate signed by it")
+	flag.StringVar(&c.PrometheusLabels, "prometheus-labels", "", "labels attached to every sample on /metrics in format key=value,key=value")
+	flag.IntVar(&c.HistorySize, "history-size", 1024, "number of samples kept per series")
+	flag.IntVar(&c.DBMaxConns, "db-max-conns", 10, "maximum number of connections in Postgres pool")
+	flag.IntVar(&c.DBMinConns, "db-min-conns", 0, "minimum number of idle connections kept in Postgres pool")
+	flag.DurationVar(&c.DBMaxConnLifetime, "db-max-conn-lifetime", time.Hour, "how long a pooled Postgres connection may live before it is recycled")
+	flag.DurationVar(&c.DBHealthCheckPeriod, "db-health-check-period", time.Minute, "how often idle pooled Postgres connections are checked")
 	flag.Parse()
 
 	err := env.Parse(c)
-	if c.DBDsn != "" {
+	if c.DBDsn != "" || c.Storage != "" {
 		c.Restore = false
 		c.StoreFile = ""
 	}